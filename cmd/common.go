@@ -2,25 +2,322 @@ package cmd
 
 import (
 	"fmt"
+	"os"
+	"regexp"
+	"strconv"
 	"strings"
+
+	"github.com/atsushi-ishibashi/influencer/svc"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+var (
+	imagePathComponent = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*$`)
+	imageTag           = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+	imageDigest        = regexp.MustCompile(`^[a-z0-9]+(?:[+._-][a-z0-9]+)*:[A-Fa-f0-9]{32,}$`)
+	imageHost          = regexp.MustCompile(`^(?:[A-Za-z0-9](?:[A-Za-z0-9-]*[A-Za-z0-9])?)(?:\.[A-Za-z0-9](?:[A-Za-z0-9-]*[A-Za-z0-9])?)*$`)
+	ecrHost            = regexp.MustCompile(`^([0-9]{12})\.dkr\.ecr\.([a-z0-9-]+)\.amazonaws\.com(?:\.cn)?$`)
 )
 
+const defaultImageTag = "latest"
+
+// strategies to choose containers to update with an image
+const (
+	matchByName       = "name"
+	matchByRepository = "repository"
+	matchByBoth       = "both"
+)
+
+// containerImage is a parsed image reference, [host[:port]/]path[:tag][@digest]
 type containerImage struct {
-	name string
-	tag  string
+	// host and port are empty for ECR repositories in the current account
+	host   string
+	port   string
+	path   string
+	tag    string
+	digest string
+	// container is the container name given explicitly by container=image
+	container string
+}
+
+// parseImageArg parses [container=]image
+func parseImageArg(s string) (containerImage, error) {
+	container := ""
+	if i := strings.Index(s, "="); i >= 0 {
+		container = s[:i]
+		s = s[i+1:]
+		if container == "" {
+			return containerImage{}, fmt.Errorf("container name is empty: %s", s)
+		}
+	}
+	ci, err := parseContainerImage(s)
+	if err != nil {
+		return ci, err
+	}
+	ci.container = container
+	return ci, nil
 }
 
-func toContainerImage(s string) (containerImage, error) {
+// parseContainerImage parses s as an image reference. tag is "latest" if neither tag nor digest is given.
+func parseContainerImage(s string) (containerImage, error) {
 	ci := containerImage{}
-	sl := strings.Split(s, ":")
-	if len(sl) != 2 {
-		return ci, fmt.Errorf("image path is invalid: %s", s)
+	invalid := fmt.Errorf("image path is invalid: %s", s)
+	rest := s
+	if i := strings.Index(rest, "@"); i >= 0 {
+		ci.digest = rest[i+1:]
+		rest = rest[:i]
+		if !imageDigest.MatchString(ci.digest) {
+			return ci, invalid
+		}
+	}
+	if i := strings.LastIndex(rest, ":"); i > strings.LastIndex(rest, "/") {
+		ci.tag = rest[i+1:]
+		rest = rest[:i]
+		if !imageTag.MatchString(ci.tag) {
+			return ci, invalid
+		}
+	}
+	if sl := strings.SplitN(rest, "/", 2); len(sl) == 2 && (strings.ContainsAny(sl[0], ".:") || sl[0] == "localhost") {
+		ci.host = sl[0]
+		rest = sl[1]
+		if i := strings.LastIndex(ci.host, ":"); i >= 0 {
+			ci.port = ci.host[i+1:]
+			ci.host = ci.host[:i]
+			if _, err := strconv.ParseUint(ci.port, 10, 16); err != nil {
+				return ci, invalid
+			}
+		}
+		if !imageHost.MatchString(ci.host) {
+			return ci, invalid
+		}
+	}
+	ci.path = rest
+	for _, v := range strings.Split(ci.path, "/") {
+		if !imagePathComponent.MatchString(v) {
+			return ci, invalid
+		}
+	}
+	if ci.tag == "" && ci.digest == "" {
+		ci.tag = defaultImageTag
 	}
-	ci.name = sl[0]
-	ci.tag = sl[1]
 	return ci, nil
 }
 
 func (c *containerImage) String() string {
-	return fmt.Sprintf("%s:%s", c.name, c.tag)
+	s := c.name()
+	if c.tag != "" {
+		s += ":" + c.tag
+	}
+	if c.digest != "" {
+		s += "@" + c.digest
+	}
+	return s
+}
+
+// registry returns host[:port]
+func (c *containerImage) registry() string {
+	if c.port != "" {
+		return c.host + ":" + c.port
+	}
+	return c.host
+}
+
+// name returns the image reference without tag and digest
+func (c *containerImage) name() string {
+	if c.host == "" {
+		return c.path
+	}
+	return c.registry() + "/" + c.path
+}
+
+func (c *containerImage) isECR() bool {
+	return c.host == "" || ecrHost.MatchString(c.host)
+}
+
+// ecrRegistry returns the account id and the region of ECR in host, which are empty for the current account
+func (c *containerImage) ecrRegistry() (string, string) {
+	m := ecrHost.FindStringSubmatch(c.host)
+	if m == nil {
+		return "", ""
+	}
+	return m[1], m[2]
+}
+
+func (c *containerImage) baseName() string {
+	return c.path[strings.LastIndex(c.path, "/")+1:]
+}
+
+func (c *containerImage) reference() string {
+	if c.digest != "" {
+		return c.digest
+	}
+	return c.tag
+}
+
+// canonicalName expands Docker Hub names like nginx to docker.io/library/nginx
+func (c *containerImage) canonicalName() string {
+	if c.host != "" && c.host != "docker.io" && c.host != "index.docker.io" {
+		return c.name()
+	}
+	if !strings.Contains(c.path, "/") {
+		return "docker.io/library/" + c.path
+	}
+	return "docker.io/" + c.path
+}
+
+// matches reports whether image, an image uri in a container definition, is the same repository as c
+func (c *containerImage) matches(image string) bool {
+	other, err := parseContainerImage(image)
+	if err != nil {
+		return false
+	}
+	if c.host == "" {
+		m := ecrHost.FindStringSubmatch(other.host)
+		return m != nil && m[2] == os.Getenv("AWS_DEFAULT_REGION") && other.path == c.path
+	}
+	if c.isECR() {
+		return other.host == c.host && other.path == c.path
+	}
+	return other.canonicalName() == c.canonicalName()
+}
+
+func validateMatchStrategy(strategy string) error {
+	switch strategy {
+	case matchByName, matchByRepository, matchByBoth:
+		return nil
+	}
+	return fmt.Errorf("match must be one of %s, %s and %s: %s", matchByName, matchByRepository, matchByBoth, strategy)
+}
+
+// matchesContainer reports whether cd should be updated with c.
+// A container given explicitly by container=image is always matched by name.
+func (c *containerImage) matchesContainer(cd *ecs.ContainerDefinition, strategy string) bool {
+	if c.container != "" {
+		return *cd.Name == c.container
+	}
+	byName := *cd.Name == c.path || (!c.isECR() && *cd.Name == c.baseName())
+	switch strategy {
+	case matchByName:
+		return byName
+	case matchByRepository:
+		return c.matches(*cd.Image)
+	}
+	return byName || c.matches(*cd.Image)
+}
+
+// swapImages returns a copy of taskDef whose containers are updated with images.
+// It fails if any of images matches no container.
+func swapImages(ecrCli *svc.EcrClient, regCli *svc.RegistryClient, taskDef *ecs.TaskDefinition, images []containerImage, match string, pinDigest bool) (*ecs.TaskDefinition, bool, error) {
+	newTaskDef := *taskDef
+	changed := false
+	used := make([]bool, len(images))
+	var containers []*ecs.ContainerDefinition
+	for _, c := range taskDef.ContainerDefinitions {
+		cc := *c
+		for i, img := range images {
+			if !img.matchesContainer(c, match) {
+				continue
+			}
+			used[i] = true
+			uri, err := resolveImage(ecrCli, regCli, &img, pinDigest)
+			if err != nil {
+				return nil, changed, err
+			}
+			cc.Image = aws.String(uri)
+			if *cc.Image != *c.Image {
+				changed = true
+			}
+			break
+		}
+		containers = append(containers, &cc)
+	}
+	for i, v := range used {
+		if !v {
+			return nil, changed, fmt.Errorf("image %s matches no container in %s:%d", images[i].String(), *taskDef.Family, *taskDef.Revision)
+		}
+	}
+	newTaskDef.ContainerDefinitions = containers
+	return &newTaskDef, changed, nil
+}
+
+// imageDigests resolves the digests of images by the name of the container of taskDef they match
+func imageDigests(ecrCli *svc.EcrClient, regCli *svc.RegistryClient, taskDef *ecs.TaskDefinition, images []containerImage, match string) (map[string]string, error) {
+	digests := map[string]string{}
+	for _, c := range taskDef.ContainerDefinitions {
+		for _, img := range images {
+			if !img.matchesContainer(c, match) {
+				continue
+			}
+			digest, err := resolveDigest(ecrCli, regCli, &img)
+			if err != nil {
+				return nil, err
+			}
+			digests[*c.Name] = digest
+			break
+		}
+	}
+	return digests, nil
+}
+
+// resolveDigest returns the digest ci currently points to in its registry
+func resolveDigest(ecrCli *svc.EcrClient, regCli *svc.RegistryClient, ci *containerImage) (string, error) {
+	if ci.isECR() {
+		img, err := fetchECRImage(ecrCli, ci)
+		if err != nil {
+			return "", err
+		}
+		return *img.ImageId.ImageDigest, nil
+	}
+	return regCli.FetchDigest(ci.registry(), ci.path, ci.reference())
+}
+
+// fetchECRImage fetches ci from the registry of its host, or of the current account and region if ci has no host
+func fetchECRImage(ecrCli *svc.EcrClient, ci *containerImage) (*ecr.Image, error) {
+	registryID, region := ci.ecrRegistry()
+	if region != "" {
+		cli, err := ecrCli.ForRegion(region)
+		if err != nil {
+			return nil, err
+		}
+		ecrCli = cli
+	}
+	if ci.digest != "" {
+		return ecrCli.FetchImageWithDigest(registryID, ci.path, ci.digest)
+	}
+	return ecrCli.FetchImageWithTag(registryID, ci.path, ci.tag)
+}
+
+// resolveImage validates ci in its registry and returns image uri for container definitions
+func resolveImage(ecrCli *svc.EcrClient, regCli *svc.RegistryClient, ci *containerImage, pinDigest bool) (string, error) {
+	if ci.isECR() {
+		dimg, err := fetchECRImage(ecrCli, ci)
+		if err != nil {
+			return "", err
+		}
+		return ecrImageURI(ci, dimg, pinDigest), nil
+	}
+	digest, err := regCli.FetchDigest(ci.registry(), ci.path, ci.reference())
+	if err != nil {
+		return "", err
+	}
+	if pinDigest || ci.digest != "" {
+		return fmt.Sprintf("%s@%s", ci.name(), digest), nil
+	}
+	return ci.String(), nil
+}
+
+// ecrImageURI returns repo@digest if pinDigest or the image has no tag, otherwise repo:tag.
+// The host of ci is kept, and the current account and region are used if ci has no host.
+func ecrImageURI(ci *containerImage, img *ecr.Image, pinDigest bool) string {
+	host := ci.host
+	if host == "" {
+		host = fmt.Sprintf("%s.dkr.ecr.%s.amazonaws.com", *img.RegistryId, os.Getenv("AWS_DEFAULT_REGION"))
+	}
+	repo := fmt.Sprintf("%s/%s", host, *img.RepositoryName)
+	if pinDigest || img.ImageId.ImageTag == nil {
+		return fmt.Sprintf("%s@%s", repo, *img.ImageId.ImageDigest)
+	}
+	return fmt.Sprintf("%s:%s", repo, *img.ImageId.ImageTag)
 }
@@ -0,0 +1,234 @@
+package cmd
+
+import (
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+const testDigest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+func TestParseImageArg(t *testing.T) {
+	cases := []struct {
+		in   string
+		want containerImage
+		err  bool
+	}{
+		{in: "app:v1", want: containerImage{path: "app", tag: "v1"}},
+		{in: "app", want: containerImage{path: "app", tag: "latest"}},
+		{in: "app@" + testDigest, want: containerImage{path: "app", digest: testDigest}},
+		{in: "app:v1@" + testDigest, want: containerImage{path: "app", tag: "v1", digest: testDigest}},
+		{in: "team/app:v1", want: containerImage{path: "team/app", tag: "v1"}},
+		{in: "registry:5000/team/app:v1", want: containerImage{host: "registry", port: "5000", path: "team/app", tag: "v1"}},
+		{in: "localhost/app", want: containerImage{host: "localhost", path: "app", tag: "latest"}},
+		{in: "ghcr.io/org/app:v1", want: containerImage{host: "ghcr.io", path: "org/app", tag: "v1"}},
+		{in: "123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/app:v1", want: containerImage{host: "123456789012.dkr.ecr.ap-northeast-1.amazonaws.com", path: "app", tag: "v1"}},
+		{in: "web=app:v1", want: containerImage{path: "app", tag: "v1", container: "web"}},
+		{in: "web=registry:5000/app", want: containerImage{host: "registry", port: "5000", path: "app", tag: "latest", container: "web"}},
+		{in: "App:v1", err: true},
+		{in: "team/App", err: true},
+		{in: "app:", err: true},
+		{in: "app:-v1", err: true},
+		{in: "app@sha256:short", err: true},
+		{in: "registry:port/app", err: true},
+		{in: "=app:v1", err: true},
+		{in: "", err: true},
+	}
+	for _, c := range cases {
+		got, err := parseImageArg(c.in)
+		if c.err {
+			if err == nil {
+				t.Errorf("parseImageArg(%q) = %+v, want error", c.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseImageArg(%q) failed: %s", c.in, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("parseImageArg(%q) = %+v, want %+v", c.in, got, c.want)
+		}
+	}
+}
+
+func TestContainerImageString(t *testing.T) {
+	for _, in := range []string{"app:v1", "registry:5000/team/app:v1", "app@" + testDigest, "ghcr.io/org/app:v1@" + testDigest} {
+		ci, err := parseContainerImage(in)
+		if err != nil {
+			t.Fatalf("parseContainerImage(%q) failed: %s", in, err)
+		}
+		if ci.String() != in {
+			t.Errorf("String() = %q, want %q", ci.String(), in)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	defer os.Setenv("AWS_DEFAULT_REGION", os.Getenv("AWS_DEFAULT_REGION"))
+	os.Setenv("AWS_DEFAULT_REGION", "ap-northeast-1")
+	cases := []struct {
+		image string
+		uri   string
+		want  bool
+	}{
+		{"app", "123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/app:v0", true},
+		{"app", "123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/app@" + testDigest, true},
+		{"app", "123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/app-worker:v0", false},
+		{"app-worker", "123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/app:v0", false},
+		{"app", "123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/team/app:v0", false},
+		{"app", "123456789012.dkr.ecr.us-east-1.amazonaws.com/app:v0", false},
+		{"app", "app:v0", false},
+		{"nginx", "nginx:1.13", false},
+		{"docker.io/nginx", "nginx:1.13", true},
+		{"docker.io/nginx", "docker.io/library/nginx", true},
+		{"docker.io/nginx", "nginx-exporter:1.0", false},
+		{"ghcr.io/org/app", "ghcr.io/org/app:v0", true},
+		{"ghcr.io/org/app", "ghcr.io/org/app-worker:v0", false},
+		{"registry:5000/team/app", "registry:5000/team/app:v0", true},
+		{"registry:5000/team/app", "registry:5001/team/app:v0", false},
+	}
+	for _, c := range cases {
+		ci, err := parseContainerImage(c.image)
+		if err != nil {
+			t.Fatalf("parseContainerImage(%q) failed: %s", c.image, err)
+		}
+		if got := ci.matches(c.uri); got != c.want {
+			t.Errorf("%s matches %s = %v, want %v", c.image, c.uri, got, c.want)
+		}
+	}
+}
+
+func TestMatchesContainer(t *testing.T) {
+	defer os.Setenv("AWS_DEFAULT_REGION", os.Getenv("AWS_DEFAULT_REGION"))
+	os.Setenv("AWS_DEFAULT_REGION", "ap-northeast-1")
+	app := &ecs.ContainerDefinition{Name: aws.String("app"), Image: aws.String("123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/app:v0")}
+	worker := &ecs.ContainerDefinition{Name: aws.String("worker"), Image: aws.String("123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/app-worker:v0")}
+	nginx := &ecs.ContainerDefinition{Name: aws.String("nginx"), Image: aws.String("123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/proxy:v0")}
+	cases := []struct {
+		image    string
+		cd       *ecs.ContainerDefinition
+		strategy string
+		want     bool
+	}{
+		{"app:v1", app, matchByRepository, true},
+		{"app:v1", worker, matchByRepository, false},
+		{"app:v1", app, matchByName, true},
+		{"app:v1", worker, matchByName, false},
+		{"proxy:v1", nginx, matchByName, false},
+		{"proxy:v1", nginx, matchByRepository, true},
+		{"nginx:v1", nginx, matchByName, true},
+		{"nginx:v1", nginx, matchByRepository, false},
+		{"nginx:v1", nginx, matchByBoth, true},
+		{"docker.io/library/nginx:1.25", nginx, matchByName, true},
+		{"worker=app:v1", worker, matchByRepository, true},
+		{"worker=app:v1", app, matchByRepository, false},
+	}
+	for _, c := range cases {
+		ci, err := parseImageArg(c.image)
+		if err != nil {
+			t.Fatalf("parseImageArg(%q) failed: %s", c.image, err)
+		}
+		if got := ci.matchesContainer(c.cd, c.strategy); got != c.want {
+			t.Errorf("%s matches container %s by %s = %v, want %v", c.image, *c.cd.Name, c.strategy, got, c.want)
+		}
+	}
+}
+
+func TestECRRegistry(t *testing.T) {
+	cases := []struct {
+		image, account, region string
+	}{
+		{"app:v1", "", ""},
+		{"123456789012.dkr.ecr.us-east-1.amazonaws.com/app:v1", "123456789012", "us-east-1"},
+		{"210987654321.dkr.ecr.cn-north-1.amazonaws.com.cn/team/app:v1", "210987654321", "cn-north-1"},
+		{"ghcr.io/org/app:v1", "", ""},
+	}
+	for _, c := range cases {
+		ci, err := parseContainerImage(c.image)
+		if err != nil {
+			t.Fatalf("parseContainerImage(%q) failed: %s", c.image, err)
+		}
+		account, region := ci.ecrRegistry()
+		if account != c.account || region != c.region {
+			t.Errorf("ecrRegistry of %s = %s, %s, want %s, %s", c.image, account, region, c.account, c.region)
+		}
+	}
+}
+
+func TestECRImageURI(t *testing.T) {
+	defer os.Setenv("AWS_DEFAULT_REGION", os.Getenv("AWS_DEFAULT_REGION"))
+	os.Setenv("AWS_DEFAULT_REGION", "ap-northeast-1")
+	img := &ecr.Image{
+		RegistryId:     aws.String("123456789012"),
+		RepositoryName: aws.String("app"),
+		ImageId:        &ecr.ImageIdentifier{ImageTag: aws.String("v1"), ImageDigest: aws.String(testDigest)},
+	}
+	cases := []struct {
+		image     string
+		pinDigest bool
+		want      string
+	}{
+		{"app:v1", false, "123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/app:v1"},
+		{"app:v1", true, "123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/app@" + testDigest},
+		{"123456789012.dkr.ecr.us-east-1.amazonaws.com/app:v1", false, "123456789012.dkr.ecr.us-east-1.amazonaws.com/app:v1"},
+		{"123456789012.dkr.ecr.us-east-1.amazonaws.com/app:v1", true, "123456789012.dkr.ecr.us-east-1.amazonaws.com/app@" + testDigest},
+	}
+	for _, c := range cases {
+		ci, err := parseContainerImage(c.image)
+		if err != nil {
+			t.Fatalf("parseContainerImage(%q) failed: %s", c.image, err)
+		}
+		if got := ecrImageURI(&ci, img, c.pinDigest); got != c.want {
+			t.Errorf("ecrImageURI of %s = %s, want %s", c.image, got, c.want)
+		}
+	}
+}
+
+func TestTaskExits(t *testing.T) {
+	taskDef := &ecs.TaskDefinition{ContainerDefinitions: []*ecs.ContainerDefinition{
+		{Name: aws.String("app")},
+		{Name: aws.String("envoy"), Essential: aws.Bool(false)},
+		{Name: aws.String("migrate"), Essential: aws.Bool(false)},
+	}}
+	task := func(app, envoy int64, overrides ...string) *ecs.Task {
+		t := &ecs.Task{
+			TaskArn: aws.String("task"),
+			Containers: []*ecs.Container{
+				{Name: aws.String("app"), ExitCode: aws.Int64(app)},
+				{Name: aws.String("envoy"), ExitCode: aws.Int64(envoy), Reason: aws.String("stopped")},
+				{Name: aws.String("migrate")},
+			},
+			Overrides: &ecs.TaskOverride{},
+		}
+		for _, v := range overrides {
+			t.Overrides.ContainerOverrides = append(t.Overrides.ContainerOverrides, &ecs.ContainerOverride{Name: aws.String(v)})
+		}
+		return t
+	}
+	cases := []struct {
+		name   string
+		task   *ecs.Task
+		failed int
+		others int
+		code   int
+	}{
+		{name: "sidecar killed", task: task(0, 137), others: 2},
+		{name: "essential failed", task: task(2, 143), failed: 1, others: 2, code: 2},
+		{name: "overridden without exit code", task: task(0, 0, "migrate"), failed: 1, others: 1, code: 1},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			failed, others, code := taskExits(taskDef, c.task)
+			if len(failed) != c.failed || code != c.code {
+				t.Errorf("failed = %v, code = %d, want %d failure(s) and %d", failed, code, c.failed, c.code)
+			}
+			if len(others) != c.others {
+				t.Errorf("others = %v, want %d", others, c.others)
+			}
+		})
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"time"
 
 	"github.com/atsushi-ishibashi/influencer/svc"
 	"github.com/atsushi-ishibashi/influencer/util"
@@ -30,7 +31,29 @@ func NewPlanCommand(out, errOut io.Writer) cli.Command {
 			},
 			cli.StringSliceFlag{
 				Name:  "image",
-				Usage: "image repo:tag, more than 1",
+				Usage: "image [container=]repo:tag, more than 1",
+			},
+			cli.StringFlag{
+				Name:  "match",
+				Usage: "how to choose containers for images without container=: name, repository or both",
+				Value: matchByName,
+			},
+			cli.BoolFlag{
+				Name:  "digest",
+				Usage: "pin containers to repo@sha256:... instead of repo:tag",
+			},
+			cli.BoolFlag{
+				Name:  "wait",
+				Usage: "wait until the service is stable, printing deployments and events",
+			},
+			cli.DurationFlag{
+				Name:  "timeout",
+				Usage: "timeout of --wait",
+				Value: defaultWaitTimeout,
+			},
+			cli.BoolFlag{
+				Name:  "auto-rollback",
+				Usage: "restore the previous task definition if the service is not stable within --timeout. implies --wait",
 			},
 			cli.BoolFlag{
 				Name:  "dry-run",
@@ -45,7 +68,7 @@ func NewPlanCommand(out, errOut io.Writer) cli.Command {
 			if err != nil {
 				return err
 			}
-			if err = p.validateECRImage(); err != nil {
+			if err = p.validateImage(); err != nil {
 				return fmt.Errorf("\x1b[31m%s\x1b[0m", err)
 			}
 			if c.Bool("dry-run") {
@@ -63,11 +86,17 @@ func NewPlanCommand(out, errOut io.Writer) cli.Command {
 }
 
 type plan struct {
-	cluster string
-	service string
-	images  []containerImage
-	ecsCli  *svc.EcsClient
-	ecrCli  *svc.EcrClient
+	cluster      string
+	service      string
+	images       []containerImage
+	pinDigest    bool
+	match        string
+	wait         bool
+	timeout      time.Duration
+	autoRollback bool
+	ecsCli       *svc.EcsClient
+	ecrCli       *svc.EcrClient
+	regCli       *svc.RegistryClient
 }
 
 func newPlan(c *cli.Context) (plan, error) {
@@ -83,8 +112,16 @@ func newPlan(c *cli.Context) (plan, error) {
 	}
 	p.cluster = c.String("cluster")
 	p.service = c.String("service")
+	p.pinDigest = c.Bool("digest")
+	p.match = c.String("match")
+	p.autoRollback = c.Bool("auto-rollback")
+	p.wait = c.Bool("wait") || p.autoRollback
+	p.timeout = c.Duration("timeout")
+	if err := validateMatchStrategy(p.match); err != nil {
+		return p, util.ErrorRed(err.Error())
+	}
 	for _, v := range c.StringSlice("image") {
-		ci, err := toContainerImage(v)
+		ci, err := parseImageArg(v)
 		if err != nil {
 			return p, err
 		}
@@ -101,6 +138,7 @@ func newPlan(c *cli.Context) (plan, error) {
 	p.ecrCli = &svc.EcrClient{ECR: ecr.New(sess, &aws.Config{
 		Region: aws.String(awsregion),
 	})}
+	p.regCli = svc.NewRegistryClient()
 	return p, nil
 }
 
@@ -128,11 +166,20 @@ func (p *plan) execute() error {
 	}
 	fmt.Println("\x1b[32m" + "Registered New Task Definition..." + "\x1b[0m")
 	util.PdiffTaskDef(regiTaskDef.String(), taskDef.String())
+	since := time.Now()
 	newServ, err := p.ecsCli.UpdateServiceWithTaskDef(serv, regiTaskDef)
 	if err != nil {
 		return err
 	}
 	fmt.Printf("\x1b[32mUpdate Service... cluster arn: %s, service name: %s, task definition: %s, task count: %d\x1b[0m\n", *newServ.ClusterArn, *newServ.ServiceName, *newServ.TaskDefinition, *newServ.DesiredCount)
+	if !p.wait {
+		return nil
+	}
+	util.PrintlnGreen(fmt.Sprintf("Waiting until updating %s finish...", p.service))
+	if err := waitServiceUpdateOrRollback(p.ecsCli, serv, p.cluster, p.service, since, p.timeout, p.autoRollback); err != nil {
+		return util.ErrorRed(err.Error())
+	}
+	util.PrintlnGreen(fmt.Sprintf("updating %s finished!!!", p.service))
 	return nil
 }
 
@@ -166,43 +213,15 @@ func (p *plan) registerTaskDefinition(taskDef *ecs.TaskDefinition) (*ecs.TaskDef
 }
 
 func (p *plan) createNewTaskDefinition(taskDef *ecs.TaskDefinition) (*ecs.TaskDefinition, bool, error) {
-	newTaskDef := *taskDef
-	changed := false
-	var containers []*ecs.ContainerDefinition
-	for _, c := range taskDef.ContainerDefinitions {
-		cc := *c
-		if img, ok := p.searchImage(*c.Name); ok {
-			dimg, err := p.ecrCli.FetchImageWithTag(img.name, img.tag)
-			if err != nil {
-				// TODO: DockerHubなどのイメージ対応
-				return nil, changed, err
-			}
-			cc.Image = aws.String(fmt.Sprintf("%s.dkr.ecr.%s.amazonaws.com/%s:%s", *dimg.RegistryId, os.Getenv("AWS_DEFAULT_REGION"), *dimg.RepositoryName, *dimg.ImageId.ImageTag))
-			if *cc.Image != *c.Image {
-				changed = true
-			}
-		}
-		containers = append(containers, &cc)
-	}
-	newTaskDef.ContainerDefinitions = containers
-	return &newTaskDef, changed, nil
+	return swapImages(p.ecrCli, p.regCli, taskDef, p.images, p.match, p.pinDigest)
 }
 
-func (p *plan) validateECRImage() error {
+func (p *plan) validateImage() error {
 	for _, v := range p.images {
-		_, err := p.ecrCli.FetchImageWithTag(v.name, v.tag)
+		_, err := resolveImage(p.ecrCli, p.regCli, &v, false)
 		if err != nil {
-			return fmt.Errorf("Not Found ECR Image %s:%s\n", v.name, v.tag)
+			return fmt.Errorf("Not Found Image %s: %s\n", v.String(), err)
 		}
 	}
 	return nil
 }
-
-func (p *plan) searchImage(imageName string) (containerImage, bool) {
-	for _, v := range p.images {
-		if v.name == imageName {
-			return v, true
-		}
-	}
-	return containerImage{}, false
-}
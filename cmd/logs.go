@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/atsushi-ishibashi/influencer/svc"
+	"github.com/atsushi-ishibashi/influencer/util"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+const (
+	logPollInterval = 5 * time.Second
+	// logDrainRetries is how many times the remaining events are fetched again after tasks stop
+	logDrainRetries = 3
+)
+
+// taskLogStream is the awslogs stream of a container in a task
+type taskLogStream struct {
+	container string
+	region    string
+	group     string
+	stream    string
+}
+
+// taskLogStreams derives awslogs streams of containers in taskDef from the task id.
+// Containers without awslogs-stream-prefix are skipped because their stream name can't be derived.
+func taskLogStreams(taskDef *ecs.TaskDefinition, taskARN string) []taskLogStream {
+	taskID := taskARN[strings.LastIndex(taskARN, "/")+1:]
+	var streams []taskLogStream
+	for _, c := range taskDef.ContainerDefinitions {
+		lc := c.LogConfiguration
+		if lc == nil || aws.StringValue(lc.LogDriver) != ecs.LogDriverAwslogs {
+			continue
+		}
+		prefix := aws.StringValue(lc.Options["awslogs-stream-prefix"])
+		if prefix == "" {
+			util.PrintlnYellow(fmt.Sprintf("\tSkip logs of %s: awslogs-stream-prefix is not set", aws.StringValue(c.Name)))
+			continue
+		}
+		streams = append(streams, taskLogStream{
+			container: aws.StringValue(c.Name),
+			region:    aws.StringValue(lc.Options["awslogs-region"]),
+			group:     aws.StringValue(lc.Options["awslogs-group"]),
+			stream:    fmt.Sprintf("%s/%s/%s", prefix, aws.StringValue(c.Name), taskID),
+		})
+	}
+	return streams
+}
+
+// streamLogs prints events of streams with a prefix per container until stop is closed.
+// The returned channel is closed after the remaining events are printed.
+func streamLogs(sess *session.Session, streams []taskLogStream, stop <-chan struct{}) <-chan struct{} {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, s := range streams {
+		wg.Add(1)
+		go func(s taskLogStream) {
+			defer wg.Done()
+			region := s.region
+			if region == "" {
+				region = os.Getenv("AWS_DEFAULT_REGION")
+			}
+			logsCli := &svc.LogsClient{CloudWatchLogs: cloudwatchlogs.New(sess, &aws.Config{
+				Region: aws.String(region),
+			})}
+			var token *string
+			for {
+				stopped := false
+				select {
+				case <-stop:
+					stopped = true
+				case <-time.After(logPollInterval):
+				}
+				// drain all events, also after stop
+				var err error
+				token, err = printLogEvents(logsCli, s, token)
+				// the remaining events after stop are fetched again a few times on retryable errors
+				for i := 0; stopped && err != nil && svc.IsRetryableError(err) && i < logDrainRetries; i++ {
+					util.PrintlnYellow(fmt.Sprintf("\t[%s] failed to fetch logs: %s. retry...", s.container, err))
+					time.Sleep(logPollInterval)
+					token, err = printLogEvents(logsCli, s, token)
+				}
+				if err != nil && (stopped || !svc.IsRetryableError(err)) {
+					util.PrintlnRed(fmt.Sprintf("\t[%s] failed to fetch logs: %s", s.container, err))
+					return
+				}
+				if err != nil {
+					util.PrintlnYellow(fmt.Sprintf("\t[%s] failed to fetch logs: %s. retry...", s.container, err))
+				}
+				if stopped {
+					return
+				}
+			}
+		}(s)
+	}
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	return done
+}
+
+// printLogEvents prints events of s after token until no more events and returns the token to continue from
+func printLogEvents(logsCli *svc.LogsClient, s taskLogStream, token *string) (*string, error) {
+	for {
+		events, next, err := logsCli.FetchLogEvents(s.group, s.stream, token)
+		if err != nil {
+			return token, err
+		}
+		token = next
+		for _, e := range events {
+			fmt.Printf("\t[%s] %s\n", s.container, aws.StringValue(e.Message))
+		}
+		if len(events) == 0 {
+			return token, nil
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/atsushi-ishibashi/influencer/util"
+	"github.com/urfave/cli"
+)
+
+func NewRenderCommand(out, errOut io.Writer) cli.Command {
+	return cli.Command{
+		Name:  "render",
+		Usage: "Print the effective sync-deploy config merged with the overlay and variables",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "path",
+				Usage: "path to yaml deploy config file",
+			},
+			cli.StringFlag{
+				Name:  "env",
+				Usage: "environment of the overlay, e.g. prod for syncdeploy.prod.yaml",
+			},
+			cli.StringSliceFlag{
+				Name:  "var",
+				Usage: "variable key=value for ${key} in the config, more than 1",
+			},
+			cli.StringFlag{
+				Name:  "vars-file",
+				Usage: "path to yaml file of variables",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if c.String("path") == "" {
+				return util.ErrorRed("--path is required")
+			}
+			t, err := renderSyncDeployConfig(c)
+			if err != nil {
+				return util.ErrorRed(err.Error())
+			}
+			buf, err := t.bytes()
+			if err != nil {
+				return util.ErrorRed(err.Error())
+			}
+			fmt.Fprint(out, string(buf))
+			return nil
+		},
+	}
+}
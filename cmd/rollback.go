@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/atsushi-ishibashi/influencer/svc"
+	"github.com/atsushi-ishibashi/influencer/util"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/urfave/cli"
+)
+
+func NewRollbackCommand(out, errOut io.Writer) cli.Command {
+	return cli.Command{
+		Name:  "rollback",
+		Usage: "Update service with a previous revision of its task definition",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "cluster",
+				Usage: "cluster name",
+			},
+			cli.StringFlag{
+				Name:  "service",
+				Usage: "service name",
+			},
+			cli.Int64Flag{
+				Name:  "to-revision",
+				Usage: "revision of the task definition family to roll back to",
+			},
+			cli.IntFlag{
+				Name:  "steps",
+				Usage: "number of active revisions to go back from the current one",
+				Value: 1,
+			},
+			cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "dry-run. output diff in pretty",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if err := util.ConfigAWS(c); err != nil {
+				return err
+			}
+			rb, err := newRollback(c)
+			if err != nil {
+				return err
+			}
+			serv, err := rb.ecsCli.FetchService(rb.cluster, rb.service)
+			if err != nil {
+				return util.ErrorRed(err.Error())
+			}
+			curTaskDef, err := rb.ecsCli.FetchTaskDefinition(*serv.TaskDefinition)
+			if err != nil {
+				return util.ErrorRed(err.Error())
+			}
+			targetTaskDef, err := rb.searchTargetTaskDefinition(curTaskDef)
+			if err != nil {
+				return util.ErrorRed(err.Error())
+			}
+			util.PrintlnYellow(fmt.Sprintf("Rollback %s:%d -> %s:%d", *curTaskDef.Family, *curTaskDef.Revision, *targetTaskDef.Family, *targetTaskDef.Revision))
+			util.PdiffTaskDef(targetTaskDef.String(), curTaskDef.String())
+			if c.Bool("dry-run") {
+				return nil
+			}
+			if err = rb.execute(serv, targetTaskDef); err != nil {
+				return util.ErrorRed(err.Error())
+			}
+			return nil
+		},
+	}
+}
+
+type rollback struct {
+	cluster    string
+	service    string
+	toRevision int64
+	steps      int
+	ecsCli     *svc.EcsClient
+}
+
+func newRollback(c *cli.Context) (rollback, error) {
+	rb := rollback{}
+	if c.String("cluster") == "" {
+		return rb, errors.New("\x1b[31m--cluster is required\x1b[0m")
+	}
+	if c.String("service") == "" {
+		return rb, errors.New("\x1b[31m--service is required\x1b[0m")
+	}
+	if c.IsSet("to-revision") && c.IsSet("steps") {
+		return rb, errors.New("\x1b[31m--to-revision and --steps are exclusive\x1b[0m")
+	}
+	if c.IsSet("to-revision") && c.Int64("to-revision") <= 0 {
+		return rb, errors.New("\x1b[31m--to-revision must be positive\x1b[0m")
+	}
+	if c.Int("steps") <= 0 {
+		return rb, errors.New("\x1b[31m--steps must be positive\x1b[0m")
+	}
+	rb.cluster = c.String("cluster")
+	rb.service = c.String("service")
+	rb.toRevision = c.Int64("to-revision")
+	rb.steps = c.Int("steps")
+	awsregion := os.Getenv("AWS_DEFAULT_REGION")
+	sess, err := session.NewSession()
+	if err != nil {
+		return rb, err
+	}
+	rb.ecsCli = &svc.EcsClient{ECS: ecs.New(sess, &aws.Config{
+		Region: aws.String(awsregion),
+	})}
+	return rb, nil
+}
+
+// searchTargetTaskDefinition walks ACTIVE revisions of the family of curTaskDef
+func (rb *rollback) searchTargetTaskDefinition(curTaskDef *ecs.TaskDefinition) (*ecs.TaskDefinition, error) {
+	arns, err := rb.ecsCli.FetchActiveTaskDefinitionArns(*curTaskDef.Family)
+	if err != nil {
+		return nil, err
+	}
+	if rb.toRevision > 0 {
+		if rb.toRevision == *curTaskDef.Revision {
+			return nil, fmt.Errorf("%s:%d is already the current revision", *curTaskDef.Family, rb.toRevision)
+		}
+		suffix := fmt.Sprintf("%s:%d", *curTaskDef.Family, rb.toRevision)
+		for _, v := range arns {
+			if svc.TaskDefinitionName(*v) == suffix {
+				return rb.ecsCli.FetchTaskDefinition(*v)
+			}
+		}
+		return nil, fmt.Errorf("Not found active task definition %s", suffix)
+	}
+	cur := -1
+	for i, v := range arns {
+		if *v == *curTaskDef.TaskDefinitionArn {
+			cur = i
+			break
+		}
+	}
+	if cur < 0 {
+		return nil, fmt.Errorf("Current task definition %s is not active", *curTaskDef.TaskDefinitionArn)
+	}
+	if cur+rb.steps >= len(arns) {
+		return nil, fmt.Errorf("There are only %d active revisions older than %s:%d", len(arns)-cur-1, *curTaskDef.Family, *curTaskDef.Revision)
+	}
+	return rb.ecsCli.FetchTaskDefinition(*arns[cur+rb.steps])
+}
+
+func (rb *rollback) execute(serv *ecs.Service, taskDef *ecs.TaskDefinition) error {
+	newServ, err := rb.ecsCli.UpdateServiceWithTaskDef(serv, taskDef)
+	if err != nil {
+		return err
+	}
+	util.PrintlnGreen(fmt.Sprintf("Update Service... cluster arn: %s, service name: %s, task definition: %s, task count: %d", *newServ.ClusterArn, *newServ.ServiceName, *newServ.TaskDefinition, *newServ.DesiredCount))
+	util.PrintlnGreen(fmt.Sprintf("Waiting until updating %s finish...", rb.service))
+	if err := rb.ecsCli.WaitUntilServiceUpdate(rb.cluster, rb.service); err != nil {
+		return err
+	}
+	util.PrintlnGreen(fmt.Sprintf("updating %s finished!!!", rb.service))
+	return nil
+}
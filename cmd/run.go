@@ -0,0 +1,250 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/atsushi-ishibashi/influencer/svc"
+	"github.com/atsushi-ishibashi/influencer/util"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/urfave/cli"
+)
+
+func NewRunCommand(out, errOut io.Writer) cli.Command {
+	return cli.Command{
+		Name:      "run",
+		Usage:     "Run a one-off task, optionally with images and command replaced",
+		ArgsUsage: "[-- command [args...]]",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "cluster",
+				Usage: "cluster name",
+			},
+			cli.StringFlag{
+				Name:  "task-definition",
+				Usage: "task definition family, family:revision or arn",
+			},
+			cli.StringSliceFlag{
+				Name:  "image",
+				Usage: "image [container=]repo:tag. a new revision is registered if given",
+			},
+			cli.StringFlag{
+				Name:  "match",
+				Usage: "how to choose containers for images without container=: name, repository or both",
+				Value: matchByRepository,
+			},
+			cli.BoolFlag{
+				Name:  "digest",
+				Usage: "pin containers to repo@sha256:... instead of repo:tag",
+			},
+			cli.StringFlag{
+				Name:  "container",
+				Usage: "container to run the command in. required if the task definition has more than 1 container",
+			},
+			cli.StringSliceFlag{
+				Name:  "command",
+				Usage: "command to override, 1 argument per flag like --command echo --command 'a b'. or give the command after --",
+			},
+			cli.StringFlag{
+				Name:  "launch-type",
+				Usage: "launch type: EC2, FARGATE or EXTERNAL",
+			},
+			cli.StringSliceFlag{
+				Name:  "subnet",
+				Usage: "subnet for awsvpc, more than 1",
+			},
+			cli.StringSliceFlag{
+				Name:  "security-group",
+				Usage: "security group for awsvpc, more than 1",
+			},
+			cli.BoolFlag{
+				Name:  "assign-public-ip",
+				Usage: "assign public ip for awsvpc",
+			},
+			cli.StringFlag{
+				Name:  "network-from-service",
+				Usage: "service in the cluster to inherit awsvpc network configuration from",
+			},
+			cli.BoolFlag{
+				Name:  "wait",
+				Usage: "wait until the task stops and exit with its exit code",
+			},
+			cli.BoolFlag{
+				Name:  "logs",
+				Usage: "stream logs of the task while waiting. implies --wait",
+			},
+			cli.DurationFlag{
+				Name:  "timeout",
+				Usage: "timeout of --wait",
+				Value: defaultWaitTimeout,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if err := util.ConfigAWS(c); err != nil {
+				return err
+			}
+			r, err := newRun(c)
+			if err != nil {
+				return err
+			}
+			code, err := r.execute()
+			if err != nil {
+				return cli.NewExitError(util.SprintRed(err.Error()), code)
+			}
+			return nil
+		},
+	}
+}
+
+type run struct {
+	cluster            string
+	taskDefinition     string
+	images             []containerImage
+	match              string
+	pinDigest          bool
+	container          string
+	command            []string
+	opts               svc.RunTaskOptions
+	networkFromService string
+	wait               bool
+	logs               bool
+	timeout            time.Duration
+	sess               *session.Session
+	ecsCli             *svc.EcsClient
+	ecrCli             *svc.EcrClient
+	regCli             *svc.RegistryClient
+}
+
+func newRun(c *cli.Context) (run, error) {
+	r := run{}
+	if c.String("cluster") == "" {
+		return r, errors.New("\x1b[31m--cluster is required\x1b[0m")
+	}
+	if c.String("task-definition") == "" {
+		return r, errors.New("\x1b[31m--task-definition is required\x1b[0m")
+	}
+	if err := validateMatchStrategy(c.String("match")); err != nil {
+		return r, util.ErrorRed(err.Error())
+	}
+	r.cluster = c.String("cluster")
+	r.taskDefinition = c.String("task-definition")
+	r.match = c.String("match")
+	r.pinDigest = c.Bool("digest")
+	for _, v := range c.StringSlice("image") {
+		ci, err := parseImageArg(v)
+		if err != nil {
+			return r, util.ErrorRed(err.Error())
+		}
+		r.images = append(r.images, ci)
+	}
+	r.container = c.String("container")
+	r.command = c.StringSlice("command")
+	if len(c.Args()) > 0 {
+		if len(r.command) > 0 {
+			return r, util.ErrorRed("--command can't be given with a command after --")
+		}
+		r.command = c.Args()
+	}
+	r.opts.LaunchType = c.String("launch-type")
+	if len(c.StringSlice("subnet")) > 0 || len(c.StringSlice("security-group")) > 0 || c.IsSet("assign-public-ip") {
+		vpc := &ecs.AwsVpcConfiguration{
+			Subnets:        aws.StringSlice(c.StringSlice("subnet")),
+			SecurityGroups: aws.StringSlice(c.StringSlice("security-group")),
+		}
+		if c.IsSet("assign-public-ip") {
+			vpc.AssignPublicIp = aws.String(ecs.AssignPublicIpDisabled)
+			if c.Bool("assign-public-ip") {
+				vpc.AssignPublicIp = aws.String(ecs.AssignPublicIpEnabled)
+			}
+		}
+		r.opts.NetworkConfiguration = &ecs.NetworkConfiguration{AwsvpcConfiguration: vpc}
+	}
+	r.networkFromService = c.String("network-from-service")
+	r.logs = c.Bool("logs")
+	r.wait = c.Bool("wait") || r.logs
+	r.timeout = c.Duration("timeout")
+	awsregion := os.Getenv("AWS_DEFAULT_REGION")
+	sess, err := session.NewSession()
+	if err != nil {
+		return r, err
+	}
+	r.sess = sess
+	r.ecsCli = &svc.EcsClient{ECS: ecs.New(sess, &aws.Config{
+		Region: aws.String(awsregion),
+	})}
+	r.ecrCli = &svc.EcrClient{ECR: ecr.New(sess, &aws.Config{
+		Region: aws.String(awsregion),
+	})}
+	r.regCli = svc.NewRegistryClient()
+	return r, nil
+}
+
+// execute runs the task and returns the exit code for the process
+func (r *run) execute() (int, error) {
+	taskDef, err := r.ecsCli.FetchTaskDefinition(r.taskDefinition)
+	if err != nil {
+		return 1, err
+	}
+	if len(r.images) > 0 {
+		newTaskDef, changed, err := swapImages(r.ecrCli, r.regCli, taskDef, r.images, r.match, r.pinDigest)
+		if err != nil {
+			return 1, err
+		}
+		if changed {
+			regiTaskDef, err := r.ecsCli.RegisterTaskDefinition(newTaskDef)
+			if err != nil {
+				return 1, err
+			}
+			util.PrintlnGreen(fmt.Sprintf("Registered task definition: %s:%d...", *regiTaskDef.Family, *regiTaskDef.Revision))
+			util.PdiffTaskDef(regiTaskDef.String(), taskDef.String())
+			taskDef = regiTaskDef
+		}
+	}
+	opts := r.opts
+	if len(r.command) > 0 {
+		o := &OverridesYamlConfig{Container: r.container, Command: r.command}
+		if opts.Overrides, err = o.taskOverride(taskDef); err != nil {
+			return 1, err
+		}
+	}
+	if r.networkFromService != "" {
+		if opts, err = inheritNetworkConfiguration(r.ecsCli, r.cluster, r.networkFromService, opts); err != nil {
+			return 1, err
+		}
+	}
+	util.PrintlnGreen(fmt.Sprintf("Running task of %s:%d on cluster %s...", *taskDef.Family, *taskDef.Revision, r.cluster))
+	rtRes, err := r.ecsCli.InvokeTask(r.cluster, taskDef, opts)
+	if err != nil {
+		return 1, err
+	}
+	if len(rtRes.Failures) > 0 {
+		return 1, fmt.Errorf("%s", rtRes.Failures)
+	}
+	taskARNs := make([]*string, 0, len(rtRes.Tasks))
+	for _, v := range rtRes.Tasks {
+		util.PrintlnGreen(fmt.Sprintf("Started task: %s", *v.TaskArn))
+		taskARNs = append(taskARNs, v.TaskArn)
+	}
+	if !r.wait {
+		return 0, nil
+	}
+	util.PrintlnGreen(fmt.Sprintf("Waiting until %s:%d finish...", *taskDef.Family, *taskDef.Revision))
+	if err := waitTasksStop(r.sess, r.ecsCli, r.cluster, taskDef, taskARNs, r.timeout, r.logs); err != nil {
+		if errors.Is(err, svc.ErrWaitTimeout) {
+			return 1, &tasksTimeoutError{err: err, cluster: r.cluster, taskARNs: taskARNs}
+		}
+		return 1, err
+	}
+	code, err := checkTasksExit(r.ecsCli, r.cluster, taskDef, taskARNs)
+	if err != nil {
+		return code, err
+	}
+	util.PrintlnGreen("Finished!!!")
+	return 0, nil
+}
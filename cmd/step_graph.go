@@ -0,0 +1,154 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/atsushi-ishibashi/influencer/util"
+)
+
+const (
+	stepPending = iota
+	stepRunning
+	stepSucceeded
+	stepFailed
+	stepSkipped
+)
+
+// stepGraph is the dependency graph of sync-deploy steps
+type stepGraph struct {
+	tasks  []*deployTask
+	byName map[string]*deployTask
+}
+
+func newStepGraph(tasks []*deployTask) (*stepGraph, error) {
+	g := &stepGraph{tasks: tasks, byName: map[string]*deployTask{}}
+	for _, dt := range tasks {
+		if _, ok := g.byName[dt.name]; ok {
+			return nil, fmt.Errorf("step name %s is duplicated", dt.name)
+		}
+		g.byName[dt.name] = dt
+	}
+	for _, dt := range tasks {
+		for _, d := range dt.dependsOn {
+			if _, ok := g.byName[d]; !ok {
+				return nil, fmt.Errorf("step %s depends on unknown step %s", dt.name, d)
+			}
+		}
+	}
+	if len(g.order()) != len(tasks) {
+		return nil, fmt.Errorf("steps have circular dependencies")
+	}
+	return g, nil
+}
+
+// order returns steps in topological order, keeping the order in yaml among independent steps.
+// Steps in a cycle are not returned.
+func (g *stepGraph) order() []*deployTask {
+	done := map[string]bool{}
+	var ordered []*deployTask
+	for len(ordered) < len(g.tasks) {
+		progressed := false
+		for _, dt := range g.tasks {
+			if done[dt.name] || !g.ready(dt, done) {
+				continue
+			}
+			done[dt.name] = true
+			ordered = append(ordered, dt)
+			progressed = true
+		}
+		if !progressed {
+			break
+		}
+	}
+	return ordered
+}
+
+func (g *stepGraph) ready(dt *deployTask, done map[string]bool) bool {
+	for _, d := range dt.dependsOn {
+		if !done[d] {
+			return false
+		}
+	}
+	return true
+}
+
+type stepResult struct {
+	name string
+	err  error
+}
+
+// run runs fn for every step except ones in skip, at most parallelism steps at once.
+// skip maps names of steps regarded as succeeded to the reason.
+// Dependents of a failed step are skipped while independent steps keep running.
+func (g *stepGraph) run(parallelism int, skip map[string]string, fn func(*deployTask) error) error {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	status := map[string]int{}
+	succeeded := map[string]bool{}
+	for _, dt := range g.tasks {
+		if reason, ok := skip[dt.name]; ok {
+			status[dt.name] = stepSucceeded
+			succeeded[dt.name] = true
+			util.PrintlnYellow(fmt.Sprintf("Skip %s: %s", dt.name, reason))
+		}
+	}
+	results := make(chan stepResult)
+	running := 0
+	var failures []string
+	for {
+		for skipped := true; skipped; {
+			skipped = false
+			for _, dt := range g.tasks {
+				if status[dt.name] != stepPending {
+					continue
+				}
+				for _, d := range dt.dependsOn {
+					if status[d] == stepFailed || status[d] == stepSkipped {
+						status[dt.name] = stepSkipped
+						util.PrintlnYellow(fmt.Sprintf("Skip %s: %s did not succeed", dt.name, d))
+						skipped = true
+						break
+					}
+				}
+			}
+		}
+		for _, dt := range g.tasks {
+			if running >= parallelism {
+				break
+			}
+			if status[dt.name] != stepPending || !g.ready(dt, succeeded) {
+				continue
+			}
+			status[dt.name] = stepRunning
+			running++
+			go func(dt *deployTask) {
+				results <- stepResult{name: dt.name, err: fn(dt)}
+			}(dt)
+		}
+		if running == 0 {
+			break
+		}
+		r := <-results
+		running--
+		if r.err != nil {
+			status[r.name] = stepFailed
+			util.PrintlnRed(fmt.Sprintf("%s failed: %s", r.name, r.err))
+			failures = append(failures, fmt.Sprintf("%s: %s", r.name, r.err))
+		} else {
+			status[r.name] = stepSucceeded
+			succeeded[r.name] = true
+		}
+	}
+	var skipped []string
+	for _, dt := range g.tasks {
+		if status[dt.name] == stepSkipped {
+			skipped = append(skipped, dt.name)
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("%d step(s) failed: %s, skipped: [%s]", len(failures), strings.Join(failures, "; "), strings.Join(skipped, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStepGraphRun(t *testing.T) {
+	step := func(name string, dependsOn ...string) *deployTask {
+		return &deployTask{name: name, dependsOn: dependsOn}
+	}
+	g, err := newStepGraph([]*deployTask{
+		step("migrate"),
+		step("api", "migrate"),
+		step("notify", "api"),
+		step("cache"),
+		step("worker"),
+		step("batch"),
+		step("cron", "cache"),
+		step("seed"),
+		step("report", "seed"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const parallelism = 2
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	var ran []string
+	err = g.run(parallelism, map[string]string{"seed": "done in the previous run"}, func(dt *deployTask) error {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		ran = append(ran, dt.name)
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		if dt.name == "migrate" {
+			return errors.New("exit 1")
+		}
+		return nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "1 step(s) failed: migrate: exit 1, skipped: [api, notify]") {
+		t.Errorf("err = %v", err)
+	}
+	if maxRunning > parallelism {
+		t.Errorf("%d steps ran at once, want at most %d", maxRunning, parallelism)
+	}
+	sort.Strings(ran)
+	want := []string{"batch", "cache", "cron", "migrate", "report", "worker"}
+	if !reflect.DeepEqual(ran, want) {
+		t.Errorf("ran %v, want %v", ran, want)
+	}
+}
+
+func TestSelectStepsDependencies(t *testing.T) {
+	g, err := newStepGraph([]*deployTask{
+		{name: "migrate"},
+		{name: "api", dependsOn: []string{"migrate"}},
+		{name: "worker", dependsOn: []string{"api"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name string
+		sel  *stepSelection
+		want []string
+		err  bool
+	}{
+		{name: "all", sel: &stepSelection{}, want: []string{"api", "migrate", "worker"}},
+		{name: "only", sel: &stepSelection{Only: []string{"api"}}, err: true},
+		{name: "only ignoring deps", sel: &stepSelection{Only: []string{"api"}, IgnoreDeps: true}, want: []string{"api"}},
+		{name: "skip", sel: &stepSelection{Skip: []string{"api"}}, err: true},
+		{name: "from", sel: &stepSelection{From: "api"}, want: []string{"api", "worker"}},
+		{name: "to", sel: &stepSelection{To: "api"}, want: []string{"api", "migrate"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			selected, err := g.selectSteps(c.sel)
+			if c.err {
+				if err == nil {
+					t.Errorf("want error, got %v", selected)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for name := range selected {
+				got = append(got, name)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("selected %v, want %v", got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/atsushi-ishibashi/influencer/util"
+)
+
+// stepSelection is --only, --skip, --from, --to and --ignore-deps. It is saved in the run state and restored on --resume.
+type stepSelection struct {
+	Only       []string `json:"only,omitempty"`
+	Skip       []string `json:"skip,omitempty"`
+	From       string   `json:"from,omitempty"`
+	To         string   `json:"to,omitempty"`
+	IgnoreDeps bool     `json:"ignore_deps,omitempty"`
+}
+
+func (s *stepSelection) isEmpty() bool {
+	return s == nil || (len(s.Only) == 0 && len(s.Skip) == 0 && s.From == "" && s.To == "" && !s.IgnoreDeps)
+}
+
+// selectSteps returns names of steps chosen by sel, or all steps if sel is empty.
+// Only and Skip match step names or labels. From and To are step names in the order of g.order().
+// Steps out of the selection don't run and dependencies on them are regarded as satisfied with a warning.
+// Dependencies on steps out of the selection other than steps before From are errors unless IgnoreDeps is set.
+func (g *stepGraph) selectSteps(sel *stepSelection) (map[string]bool, error) {
+	if sel == nil {
+		sel = &stepSelection{}
+	}
+	only, skip, from, to := sel.Only, sel.Skip, sel.From, sel.To
+	ordered := g.order()
+	index := map[string]int{}
+	for i, dt := range ordered {
+		index[dt.name] = i
+	}
+	first, last := 0, len(ordered)-1
+	if from != "" {
+		i, ok := index[from]
+		if !ok {
+			return nil, fmt.Errorf("--from %s is not a step name", from)
+		}
+		first = i
+	}
+	if to != "" {
+		i, ok := index[to]
+		if !ok {
+			return nil, fmt.Errorf("--to %s is not a step name", to)
+		}
+		last = i
+	}
+	if first > last {
+		return nil, fmt.Errorf("--from %s comes after --to %s", from, to)
+	}
+	for _, v := range append(append([]string{}, only...), skip...) {
+		if !g.matchesAny(v) {
+			return nil, fmt.Errorf("%s matches no step name or label", v)
+		}
+	}
+	selected := map[string]bool{}
+	for _, dt := range ordered[first : last+1] {
+		if len(only) > 0 && !dt.matchesSelector(only) {
+			continue
+		}
+		if dt.matchesSelector(skip) {
+			continue
+		}
+		selected[dt.name] = true
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("no step is selected")
+	}
+	// dependencies before --from are bypassed on purpose and the others need --ignore-deps
+	var bypassed, unselected []string
+	for _, dt := range ordered {
+		if !selected[dt.name] {
+			continue
+		}
+		for _, d := range dt.dependsOn {
+			if selected[d] {
+				continue
+			}
+			msg := fmt.Sprintf("%s depends on %s which is not selected", dt.name, d)
+			bypassed = append(bypassed, msg)
+			if index[d] >= first {
+				unselected = append(unselected, msg)
+			}
+		}
+	}
+	if len(unselected) > 0 && !sel.IgnoreDeps {
+		return nil, fmt.Errorf("%s. select the dependencies too, or run without them with --ignore-deps", strings.Join(unselected, ", "))
+	}
+	for _, v := range bypassed {
+		util.PrintlnYellow(fmt.Sprintf("Ignore dependency: %s", v))
+	}
+	return selected, nil
+}
+
+func (g *stepGraph) matchesAny(sel string) bool {
+	for _, dt := range g.tasks {
+		if dt.matchesSelector([]string{sel}) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesSelector reports whether any of sels is the name or a label of dt
+func (dt *deployTask) matchesSelector(sels []string) bool {
+	for _, sel := range sels {
+		if dt.name == sel {
+			return true
+		}
+		for _, l := range dt.labels {
+			if l == sel {
+				return true
+			}
+		}
+	}
+	return false
+}
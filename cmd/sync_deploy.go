@@ -1,13 +1,19 @@
 package cmd
 
 import (
+	"bufio"
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"os"
-	"regexp"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
-	yaml "gopkg.in/yaml.v2"
+	yaml "gopkg.in/yaml.v3"
 
 	"github.com/atsushi-ishibashi/influencer/svc"
 	"github.com/atsushi-ishibashi/influencer/util"
@@ -27,6 +33,86 @@ func NewSyncDeployCommand(out, errOut io.Writer) cli.Command {
 				Name:  "path",
 				Usage: "path to yaml deploy config file",
 			},
+			cli.StringFlag{
+				Name:  "env",
+				Usage: "environment of the overlay, e.g. prod for syncdeploy.prod.yaml",
+			},
+			cli.StringSliceFlag{
+				Name:  "var",
+				Usage: "variable key=value for ${key} in the config, more than 1",
+			},
+			cli.StringFlag{
+				Name:  "vars-file",
+				Usage: "path to yaml file of variables",
+			},
+			cli.StringFlag{
+				Name:  "match",
+				Usage: "how to choose containers for images without container= in steps without match: name, repository or both",
+				Value: matchByRepository,
+			},
+			cli.BoolFlag{
+				Name:  "digest",
+				Usage: "pin containers of every step to repo@sha256:... instead of repo:tag",
+			},
+			cli.DurationFlag{
+				Name:  "timeout",
+				Usage: "timeout of waiting until each service is stable or each task stops, in steps without timeout",
+				Value: defaultWaitTimeout,
+			},
+			cli.IntFlag{
+				Name:  "retries",
+				Usage: "number of retries of each step whose task or service update failed, in steps without retries",
+			},
+			cli.DurationFlag{
+				Name:  "retry-backoff",
+				Usage: "wait before the first retry, doubled every retry, in steps without retry_backoff",
+				Value: defaultRetryBackoff,
+			},
+			cli.BoolFlag{
+				Name:  "auto-rollback",
+				Usage: "restore the previous task definition of every service step which is not stable within --timeout",
+			},
+			cli.IntFlag{
+				Name:  "parallelism",
+				Usage: "max number of steps run concurrently",
+				Value: 1,
+			},
+			cli.StringFlag{
+				Name:  "state",
+				Usage: "path to write the run state to (default: <path>.state.json)",
+			},
+			cli.StringFlag{
+				Name:  "resume",
+				Usage: "path to the run state of a failed run. only failed and pending steps run",
+			},
+			cli.StringSliceFlag{
+				Name:  "only",
+				Usage: "run only steps with the name or label, more than 1",
+			},
+			cli.StringSliceFlag{
+				Name:  "skip",
+				Usage: "don't run steps with the name or label, more than 1",
+			},
+			cli.StringFlag{
+				Name:  "from",
+				Usage: "run steps from the named step in the order of execution",
+			},
+			cli.StringFlag{
+				Name:  "to",
+				Usage: "run steps up to the named step in the order of execution",
+			},
+			cli.BoolFlag{
+				Name:  "ignore-deps",
+				Usage: "run selected steps even if they depend on steps out of the selection",
+			},
+			cli.BoolFlag{
+				Name:  "confirm-each",
+				Usage: "ask for approval before every step as if all steps had confirm: true",
+			},
+			cli.BoolFlag{
+				Name:  "yes",
+				Usage: "approve steps which need confirmation without asking",
+			},
 			cli.BoolFlag{
 				Name:  "dry-run",
 				Usage: "dry-run. output diff in pretty",
@@ -36,53 +122,173 @@ func NewSyncDeployCommand(out, errOut io.Writer) cli.Command {
 			if err := util.ConfigAWS(c); err != nil {
 				return util.ErrorRed(err.Error())
 			}
+			if err := validateMatchStrategy(c.String("match")); err != nil {
+				return util.ErrorRed(err.Error())
+			}
+			if c.Int("retries") < 0 {
+				return util.ErrorRed("--retries must not be negative")
+			}
 			sd, err := newSyncDeploy(c)
 			if err != nil {
 				return util.ErrorRed(err.Error())
 			}
-			if err = sd.validateECRImage(); err != nil {
+			if err = sd.validateImage(); err != nil {
 				return util.ErrorRed(err.Error())
 			}
-			for _, dt := range sd.deployTasks {
-				ltd, err := sd.ecsCli.FetchLatestTaskDefinition(dt.taskDefinition)
-				if err != nil {
-					return util.ErrorRed(err.Error())
+			g, err := newStepGraph(sd.deployTasks)
+			if err != nil {
+				return util.ErrorRed(err.Error())
+			}
+			sel := &stepSelection{
+				Only:       c.StringSlice("only"),
+				Skip:       c.StringSlice("skip"),
+				From:       c.String("from"),
+				To:         c.String("to"),
+				IgnoreDeps: c.Bool("ignore-deps"),
+			}
+			var state *runState
+			if c.String("resume") != "" {
+				if !sel.isEmpty() {
+					return util.ErrorRed("--only, --skip, --from, --to and --ignore-deps can't be given with --resume. the selection of the run is restored from the state")
 				}
-				ntd, err := sd.createNewTaskDefinition(ltd, dt.image)
-				if err != nil {
+				if state, err = loadRunState(c.String("resume"), sd.configHash, sd.deployTasks); err != nil {
 					return util.ErrorRed(err.Error())
 				}
-				sd.printWorkFlow(dt, ltd, ntd)
-				if c.Bool("dry-run") == false {
-					err = sd.execute(dt, ltd, ntd)
+				sel = state.Selection
+			}
+			selected, err := g.selectSteps(sel)
+			if err != nil {
+				return util.ErrorRed(err.Error())
+			}
+			if c.Bool("dry-run") {
+				for _, dt := range g.order() {
+					if !selected[dt.name] {
+						continue
+					}
+					_, _, unchanged, err := sd.prepareStep(dt)
 					if err != nil {
 						return util.ErrorRed(err.Error())
 					}
+					if unchanged {
+						sd.addUnchanged(dt.name)
+					}
+				}
+				sd.printUnchanged()
+				return nil
+			}
+			if state == nil {
+				statePath := c.String("state")
+				if statePath == "" {
+					statePath = c.String("path") + ".state.json"
+				}
+				state = newRunState(statePath, c.String("path"), sd.configHash, sd.deployTasks, sel, selected)
+				if err := state.save(); err != nil {
+					return util.ErrorRed(err.Error())
+				}
+			}
+			sd.state = state
+			util.PrintlnGreen(fmt.Sprintf("Run state: %s", sd.state.path))
+			if err := runHooks("before", sd.hooks.before(), sd.runHookEnv()); err != nil {
+				runFailureHooks("on_failure", sd.hooks.onFailure(), sd.runHookEnv(), err)
+				return util.ErrorRed(err.Error())
+			}
+			skip := map[string]string{}
+			for name := range sd.state.succeededSteps() {
+				skip[name] = "already succeeded"
+			}
+			for _, dt := range sd.deployTasks {
+				if !selected[dt.name] {
+					skip[dt.name] = "not selected"
 				}
 			}
+			err = g.run(c.Int("parallelism"), skip, sd.runStep)
+			sd.printUnchanged()
+			if err == nil {
+				err = runHooks("after", sd.hooks.after(), sd.runHookEnv())
+			}
+			if err != nil {
+				runFailureHooks("on_failure", sd.hooks.onFailure(), sd.runHookEnv(), err)
+				return util.ErrorRed(fmt.Sprintf("%s\nResume with --resume %s", err, sd.state.path))
+			}
 			return nil
 		},
 	}
 }
 
+// run policies of steps
+const (
+	stepRunAlways   = "always"
+	stepRunOnChange = "on_change"
+)
+
 type deployTask struct {
+	name           string
+	labels         []string
+	dependsOn      []string
 	taskDefinition string
-	image          *containerImage
+	images         []containerImage
 	cluster        string
 	service        string
+	pinDigest      bool
+	match          string
+	autoRollback   bool
+	confirm        bool
+	run            string
+	// timeout, retries and retryBackoff are the global ones if not set in the step
+	timeout      time.Duration
+	retries      *int
+	retryBackoff time.Duration
+	// runTask, networkFromService and overrides are for tasks without service
+	runTask            svc.RunTaskOptions
+	networkFromService string
+	overrides          *OverridesYamlConfig
+	hooks              *HooksYamlConfig
+	// pos is the position of the step in the config for errors
+	pos string
 }
 
 type syncDeploy struct {
-	deployTasks []*deployTask
-	ecsCli      *svc.EcsClient
-	ecrCli      *svc.EcrClient
+	deployTasks  []*deployTask
+	hooks        *HooksYamlConfig
+	timeout      time.Duration
+	retries      int
+	retryBackoff time.Duration
+	autoRollback bool
+	match        string
+	pinDigest    bool
+	confirmEach  bool
+	yes          bool
+	stdin        *bufio.Reader
+	printMu      sync.Mutex
+	confirmMu    sync.Mutex
+	configHash   string
+	state        *runState
+	unchanged    []string
+	sess         *session.Session
+	ecsCli       *svc.EcsClient
+	ecrCli       *svc.EcrClient
+	regCli       *svc.RegistryClient
 }
 
 func newSyncDeploy(c *cli.Context) (*syncDeploy, error) {
-	sd := &syncDeploy{}
+	sd := &syncDeploy{
+		timeout:      c.Duration("timeout"),
+		retries:      c.Int("retries"),
+		retryBackoff: c.Duration("retry-backoff"),
+		autoRollback: c.Bool("auto-rollback"),
+		match:        c.String("match"),
+		pinDigest:    c.Bool("digest"),
+		confirmEach:  c.Bool("confirm-each"),
+		yes:          c.Bool("yes"),
+		stdin:        bufio.NewReader(os.Stdin),
+	}
 	//path flag
 	if c.String("path") != "" {
-		if err := sd.parseYaml(c.String("path")); err != nil {
+		t, err := renderSyncDeployConfig(c)
+		if err != nil {
+			return nil, err
+		}
+		if err := sd.parseYaml(t); err != nil {
 			return nil, err
 		}
 	}
@@ -91,40 +297,292 @@ func newSyncDeploy(c *cli.Context) (*syncDeploy, error) {
 	if err != nil {
 		return nil, err
 	}
+	sd.sess = sess
 	sd.ecsCli = &svc.EcsClient{ECS: ecs.New(sess, &aws.Config{
 		Region: aws.String(awsregion),
 	})}
 	sd.ecrCli = &svc.EcrClient{ECR: ecr.New(sess, &aws.Config{
 		Region: aws.String(awsregion),
 	})}
+	sd.regCli = svc.NewRegistryClient()
 	return sd, nil
 }
 
-func (sd *syncDeploy) execute(dt *deployTask, ltd, ntd *ecs.TaskDefinition) error {
+// prepareStep creates the new task definition of dt and prints the work flow.
+// unchanged is true if dt runs on_change and has nothing to deploy.
+func (sd *syncDeploy) prepareStep(dt *deployTask) (*ecs.TaskDefinition, *ecs.TaskDefinition, bool, error) {
+	ltd, err := sd.ecsCli.FetchLatestTaskDefinition(dt.taskDefinition)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	match := dt.match
+	if match == "" {
+		match = sd.match
+	}
+	ntd, changed, err := sd.createNewTaskDefinition(ltd, dt.images, match, sd.pinDigest || dt.pinDigest)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	unchanged := false
+	if dt.run == stepRunOnChange && !changed {
+		// a tag pushed again leaves the task definition as it is, so the digests are compared
+		digests, err := imageDigests(sd.ecrCli, sd.regCli, ltd, dt.images, match)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		if unchanged, err = sd.isDeployed(dt, ltd, digests); err != nil {
+			return nil, nil, false, err
+		}
+	}
+	sd.printWorkFlow(dt, ltd, ntd)
+	if unchanged {
+		util.PrintlnYellow(fmt.Sprintf("\t%s:%d already has the images. skip", *ltd.Family, *ltd.Revision))
+	}
+	return ltd, ntd, unchanged, nil
+}
+
+// isDeployed reports whether ltd is in effect with the image digests by container: the service uses ltd
+// and its running tasks of ltd have the digests, or for one-shot tasks, the last task of ltd had the digests and succeeded
+func (sd *syncDeploy) isDeployed(dt *deployTask, ltd *ecs.TaskDefinition, digests map[string]string) (bool, error) {
+	if dt.service == "" {
+		last, err := sd.lastStoppedTask(dt, ltd)
+		if err != nil {
+			return false, err
+		}
+		switch {
+		case last == nil || !taskSucceeded(ltd, last):
+			util.PrintlnYellow(fmt.Sprintf("\t%s:%d has the images but no successful task of it is found. run again", *ltd.Family, *ltd.Revision))
+			return false, nil
+		case !taskHasDigests(last, digests):
+			util.PrintlnYellow(fmt.Sprintf("\tthe last task of %s:%d ran other digests of the images. run again", *ltd.Family, *ltd.Revision))
+			return false, nil
+		}
+		return true, nil
+	}
+	serv, err := sd.ecsCli.FetchService(dt.cluster, dt.service)
+	if err != nil {
+		return false, err
+	}
+	if aws.StringValue(serv.TaskDefinition) != aws.StringValue(ltd.TaskDefinitionArn) {
+		return false, nil
+	}
+	tasks, err := sd.ecsCli.FetchServiceTasks(dt.cluster, dt.service)
+	if err != nil {
+		return false, err
+	}
+	found := false
+	for _, t := range tasks {
+		if aws.StringValue(t.TaskDefinitionArn) != aws.StringValue(ltd.TaskDefinitionArn) {
+			continue
+		}
+		found = true
+		if !taskHasDigests(t, digests) {
+			util.PrintlnYellow(fmt.Sprintf("\ttasks of %s run other digests of the images. deploy again", dt.service))
+			return false, nil
+		}
+	}
+	if !found {
+		util.PrintlnYellow(fmt.Sprintf("\tno running task of %s:%d is found in %s. deploy again", *ltd.Family, *ltd.Revision, dt.service))
+	}
+	return found, nil
+}
+
+// lastStoppedTask returns the task of ltd which stopped last, or nil if not found
+func (sd *syncDeploy) lastStoppedTask(dt *deployTask, ltd *ecs.TaskDefinition) (*ecs.Task, error) {
+	tasks, err := sd.ecsCli.FetchStoppedTasks(dt.cluster, *ltd.Family)
+	if err != nil {
+		return nil, err
+	}
+	var last *ecs.Task
+	for _, t := range tasks {
+		if aws.StringValue(t.TaskDefinitionArn) != aws.StringValue(ltd.TaskDefinitionArn) {
+			continue
+		}
+		if last == nil || aws.TimeValue(t.StoppedAt).After(aws.TimeValue(last.StoppedAt)) {
+			last = t
+		}
+	}
+	return last, nil
+}
+
+// taskSucceeded reports whether the essential and overridden containers of t exited with 0
+func taskSucceeded(taskDef *ecs.TaskDefinition, t *ecs.Task) bool {
+	failed, _, _ := taskExits(taskDef, t)
+	return len(failed) == 0
+}
+
+// taskHasDigests reports whether the containers of t ran the image digests by container name.
+// A container without digest, e.g. not pulled yet, doesn't have them.
+func taskHasDigests(t *ecs.Task, digests map[string]string) bool {
+	for _, c := range t.Containers {
+		digest, ok := digests[aws.StringValue(c.Name)]
+		if ok && aws.StringValue(c.ImageDigest) != digest {
+			return false
+		}
+	}
+	return true
+}
+
+func (sd *syncDeploy) addUnchanged(name string) {
+	sd.printMu.Lock()
+	defer sd.printMu.Unlock()
+	sd.unchanged = append(sd.unchanged, name)
+}
+
+// printUnchanged prints the summary of steps skipped because of no change
+func (sd *syncDeploy) printUnchanged() {
+	if len(sd.unchanged) == 0 {
+		return
+	}
+	util.PrintlnYellow(fmt.Sprintf("Skipped %d unchanged step(s): %s", len(sd.unchanged), strings.Join(sd.unchanged, ", ")))
+}
+
+func (sd *syncDeploy) runStep(dt *deployTask) error {
+	err := runHooks(dt.name+" before", dt.hooks.before(), sd.stepHookEnv(dt))
+	if err == nil {
+		err = sd.registerAndExecute(dt)
+	}
+	if err == nil {
+		err = runHooks(dt.name+" after", dt.hooks.after(), sd.stepHookEnv(dt))
+	}
+	if err != nil {
+		runFailureHooks(dt.name+" on_failure", dt.hooks.onFailure(), sd.stepHookEnv(dt), err)
+	}
+	if serr := sd.state.setResult(dt.name, err); serr != nil {
+		util.PrintlnRed(fmt.Sprintf("Failed to save run state: %s", serr))
+	}
+	return err
+}
+
+// registerAndExecute registers the new task definition of dt, or reuses the one registered in the previous run
+func (sd *syncDeploy) registerAndExecute(dt *deployTask) error {
+	if arn := sd.state.registered(dt.name); arn != "" {
+		regiTaskDef, err := sd.ecsCli.FetchTaskDefinition(arn)
+		if err != nil {
+			return err
+		}
+		util.PrintlnGreen(fmt.Sprintf("%s: reuse task definition %s:%d registered in the previous run", dt.name, *regiTaskDef.Family, *regiTaskDef.Revision))
+		// the revision before the registered one is shown as the current one
+		var prevTaskDef *ecs.TaskDefinition
+		if *regiTaskDef.Revision > 1 {
+			prevTaskDef, err = sd.ecsCli.FetchTaskDefinition(fmt.Sprintf("%s:%d", *regiTaskDef.Family, *regiTaskDef.Revision-1))
+			if err != nil {
+				return err
+			}
+		}
+		sd.printWorkFlow(dt, prevTaskDef, regiTaskDef)
+		if err := sd.confirmStep(dt, prevTaskDef, regiTaskDef); err != nil {
+			return err
+		}
+		return sd.executeWithRetries(dt, regiTaskDef)
+	}
+	ltd, ntd, unchanged, err := sd.prepareStep(dt)
+	if err != nil {
+		return err
+	}
+	if unchanged {
+		sd.addUnchanged(dt.name)
+		return nil
+	}
+	if err := sd.confirmStep(dt, ltd, ntd); err != nil {
+		return err
+	}
 	regiTaskDef, err := sd.ecsCli.RegisterTaskDefinition(ntd)
 	if err != nil {
 		return err
 	}
-	util.PrintlnGreen("\tExecuting...")
+	if err := sd.state.setRegistered(dt.name, *regiTaskDef.TaskDefinitionArn); err != nil {
+		util.PrintlnRed(fmt.Sprintf("Failed to save run state: %s", err))
+	}
 	util.PrintlnGreen(fmt.Sprintf("\tRegistered task definition: %s:%d...", *regiTaskDef.Family, *regiTaskDef.Revision))
+	return sd.executeWithRetries(dt, regiTaskDef)
+}
+
+// executeWithRetries executes dt with regiTaskDef again on failure, waiting retry backoff doubled every retry
+func (sd *syncDeploy) executeWithRetries(dt *deployTask, regiTaskDef *ecs.TaskDefinition) error {
+	retries, backoff := sd.retries, sd.retryBackoff
+	if dt.retries != nil {
+		retries = *dt.retries
+	}
+	if dt.retryBackoff > 0 {
+		backoff = dt.retryBackoff
+	}
+	err := sd.execute(dt, regiTaskDef)
+	for i := 1; err != nil && i <= retries; i++ {
+		// a timed out one-shot task is still running and must not run twice at once
+		if terr, ok := err.(*tasksTimeoutError); ok {
+			util.PrintlnYellow(fmt.Sprintf("%s timed out. stopping the task(s) before retry...", dt.name))
+			if serr := sd.stopTasks(terr.cluster, terr.taskARNs); serr != nil {
+				return fmt.Errorf("%s, and failed to stop them: %s", err, serr)
+			}
+		}
+		util.PrintlnYellow(fmt.Sprintf("%s failed: %s. retry %d/%d in %s...", dt.name, err, i, retries, backoff))
+		time.Sleep(backoff)
+		backoff *= 2
+		err = sd.execute(dt, regiTaskDef)
+	}
+	return err
+}
+
+// tasksTimeoutError is the error of a one-shot step whose tasks didn't stop within the timeout
+type tasksTimeoutError struct {
+	err      error
+	cluster  string
+	taskARNs []*string
+}
+
+func (e *tasksTimeoutError) Error() string {
+	return fmt.Sprintf("%s. task(s) may still be running: %s", e.err, strings.Join(aws.StringValueSlice(e.taskARNs), ", "))
+}
+
+func (e *tasksTimeoutError) Unwrap() error {
+	return e.err
+}
+
+// stopTasks stops tasks and waits until they stop
+func (sd *syncDeploy) stopTasks(cluster string, taskARNs []*string) error {
+	if err := sd.ecsCli.StopTasks(cluster, taskARNs, "timed out in influencer sync-deploy"); err != nil {
+		return err
+	}
+	return sd.ecsCli.WaitUntilTasksStop(cluster, taskARNs, stopTasksTimeout)
+}
+
+func (sd *syncDeploy) stepTimeout(dt *deployTask) time.Duration {
+	if dt.timeout > 0 {
+		return dt.timeout
+	}
+	return sd.timeout
+}
+
+func (sd *syncDeploy) execute(dt *deployTask, regiTaskDef *ecs.TaskDefinition) error {
+	util.PrintlnGreen("\tExecuting...")
 	if dt.service == "" {
 		util.PrintlnGreen(fmt.Sprintf("\tRunning task of %s:%d on cluster %s...", *regiTaskDef.Family, *regiTaskDef.Revision, dt.cluster))
-		rtRes, err := sd.ecsCli.InvokeTask(dt.cluster, regiTaskDef)
+		opts, err := sd.runTaskOptions(dt, regiTaskDef)
+		if err != nil {
+			return err
+		}
+		rtRes, err := sd.ecsCli.InvokeTask(dt.cluster, regiTaskDef, opts)
 		if err != nil {
 			return err
 		}
 		if len(rtRes.Failures) > 0 {
 			return fmt.Errorf("%s", rtRes.Failures)
 		}
-		taskARNs := make([]*string, len(rtRes.Tasks))
+		taskARNs := make([]*string, 0, len(rtRes.Tasks))
 		for _, v := range rtRes.Tasks {
 			taskARNs = append(taskARNs, v.TaskArn)
 		}
 		util.PrintlnGreen(fmt.Sprintf("\tWaiting until %s finish...", dt.taskDefinition))
-		// FIXME: WaitUntilTasksStop stopping...
-		// if err := sd.ecsCli.WaitUntilTasksStop(taskARNs); err != nil {
-		// 	return err
-		// }
+		if err := waitTasksStop(sd.sess, sd.ecsCli, dt.cluster, regiTaskDef, taskARNs, sd.stepTimeout(dt), true); err != nil {
+			if errors.Is(err, svc.ErrWaitTimeout) {
+				return &tasksTimeoutError{err: err, cluster: dt.cluster, taskARNs: taskARNs}
+			}
+			return err
+		}
+		if _, err := checkTasksExit(sd.ecsCli, dt.cluster, regiTaskDef, taskARNs); err != nil {
+			return err
+		}
 		util.PrintlnGreen(fmt.Sprintf("\t%s finished!!!", dt.taskDefinition))
 	} else {
 		curSer, err := sd.ecsCli.FetchService(dt.cluster, dt.service)
@@ -132,12 +590,13 @@ func (sd *syncDeploy) execute(dt *deployTask, ltd, ntd *ecs.TaskDefinition) erro
 			return err
 		}
 		util.PrintlnGreen(fmt.Sprintf("\tUpdating service %s...", dt.service))
+		since := time.Now()
 		_, err = sd.ecsCli.UpdateServiceWithTaskDef(curSer, regiTaskDef)
 		if err != nil {
 			return err
 		}
 		util.PrintlnGreen(fmt.Sprintf("\tWaiting until updating %s finish...", dt.service))
-		if err := sd.ecsCli.WaitUntilServiceUpdate(dt.cluster, dt.service); err != nil {
+		if err := waitServiceUpdateOrRollback(sd.ecsCli, curSer, dt.cluster, dt.service, since, sd.stepTimeout(dt), sd.autoRollback || dt.autoRollback); err != nil {
 			return err
 		}
 		util.PrintlnGreen(fmt.Sprintf("\tupdating %s finished!!!", dt.service))
@@ -146,92 +605,449 @@ func (sd *syncDeploy) execute(dt *deployTask, ltd, ntd *ecs.TaskDefinition) erro
 	return nil
 }
 
+// runTaskOptions fills overrides for taskDef and network configuration of dt with that of networkFromService
+func (sd *syncDeploy) runTaskOptions(dt *deployTask, taskDef *ecs.TaskDefinition) (svc.RunTaskOptions, error) {
+	opts := dt.runTask
+	if dt.overrides != nil {
+		to, err := dt.overrides.taskOverride(taskDef)
+		if err != nil {
+			return opts, err
+		}
+		opts.Overrides = to
+	}
+	if dt.networkFromService == "" {
+		return opts, nil
+	}
+	return inheritNetworkConfiguration(sd.ecsCli, dt.cluster, dt.networkFromService, opts)
+}
+
+// printWorkFlow prints the summary of dt updating ltd to ntd. ltd is nil if ntd is the first revision.
 func (sd *syncDeploy) printWorkFlow(dt *deployTask, ltd, ntd *ecs.TaskDefinition) {
+	sd.printMu.Lock()
+	defer sd.printMu.Unlock()
 	if dt.service == "" {
 		fmt.Println("Deploy oneshot task:")
 	} else {
 		fmt.Println("Deploy service task:")
 	}
+	fmt.Printf("\tname: %s\n", dt.name)
 	fmt.Printf("\tcluster: %s\n", dt.cluster)
 	if dt.service != "" {
 		fmt.Printf("\tservice: %s\n", dt.service)
 	}
 	fmt.Printf("\ttask definition: %s\n", dt.taskDefinition)
-	util.PrintlnRed(fmt.Sprintf("\t\t- %s:%d", *ltd.Family, *ltd.Revision))
-	for _, vv := range ltd.ContainerDefinitions {
-		util.PrintlnRed(fmt.Sprintf("\t\t- %s", *vv.Image))
+	revision := *ntd.Revision
+	if ltd != nil {
+		util.PrintlnRed(fmt.Sprintf("\t\t- %s:%d", *ltd.Family, *ltd.Revision))
+		for _, vv := range ltd.ContainerDefinitions {
+			util.PrintlnRed(fmt.Sprintf("\t\t- %s", *vv.Image))
+		}
+		// ntd is a copy of ltd to register unless it is registered already
+		if aws.StringValue(ntd.TaskDefinitionArn) == aws.StringValue(ltd.TaskDefinitionArn) {
+			revision++
+		}
 	}
-	util.PrintlnGreen(fmt.Sprintf("\t\t+ %s:%d", *ntd.Family, *ntd.Revision+1))
+	util.PrintlnGreen(fmt.Sprintf("\t\t+ %s:%d", *ntd.Family, revision))
 	for _, vv := range ntd.ContainerDefinitions {
 		util.PrintlnGreen(fmt.Sprintf("\t\t+ %s", *vv.Image))
 	}
-	fmt.Printf("\tcontainer imager: %s\n", dt.image.String())
+	for _, img := range dt.images {
+		fmt.Printf("\tcontainer imager: %s\n", img.String())
+	}
 }
 
-func (sd *syncDeploy) createNewTaskDefinition(taskDef *ecs.TaskDefinition, container *containerImage) (*ecs.TaskDefinition, error) {
-	reg := regexp.MustCompile(fmt.Sprintf(".+dkr.ecr.%s.amazonaws.com/%s", os.Getenv("AWS_DEFAULT_REGION"), container.name))
-	newTaskDef := *taskDef
-	var containers []*ecs.ContainerDefinition
-	for _, c := range taskDef.ContainerDefinitions {
-		cc := *c
-		if reg.MatchString(*c.Image) {
-			dimg, err := sd.ecrCli.FetchImageWithTag(container.name, container.tag)
-			if err != nil {
-				// TODO: DockerHubなどのイメージ対応
-				return nil, err
-			}
-			cc.Image = aws.String(fmt.Sprintf("%s.dkr.ecr.%s.amazonaws.com/%s:%s", *dimg.RegistryId, os.Getenv("AWS_DEFAULT_REGION"), *dimg.RepositoryName, *dimg.ImageId.ImageTag))
+func (sd *syncDeploy) createNewTaskDefinition(taskDef *ecs.TaskDefinition, images []containerImage, match string, pinDigest bool) (*ecs.TaskDefinition, bool, error) {
+	return swapImages(sd.ecrCli, sd.regCli, taskDef, images, match, pinDigest)
+}
+
+type DeployTaskYamlConfig struct {
+	Name         string           `yaml:"name"`
+	Labels       []string         `yaml:"labels"`
+	Task         string           `yaml:"task"`
+	Image        string           `yaml:"image"`
+	Images       ImagesYamlConfig `yaml:"images"`
+	Cluster      string           `yaml:"cluster"`
+	Service      string           `yaml:"service"`
+	Digest       bool             `yaml:"digest"`
+	Match        string           `yaml:"match"`
+	AutoRollback bool             `yaml:"auto_rollback"`
+	Confirm      bool             `yaml:"confirm"`
+	Run          string           `yaml:"run"`
+	Timeout      string           `yaml:"timeout"`
+	Retries      *int             `yaml:"retries"`
+	RetryBackoff string           `yaml:"retry_backoff"`
+
+	// DependsOn is names of steps to finish before this step.
+	// The previous step if it's not given, no step if it's empty.
+	DependsOn []string `yaml:"depends_on"`
+
+	LaunchType               string                        `yaml:"launch_type"`
+	CapacityProviderStrategy []*CapacityProviderYamlConfig `yaml:"capacity_provider_strategy"`
+	PlatformVersion          string                        `yaml:"platform_version"`
+	Subnets                  []string                      `yaml:"subnets"`
+	SecurityGroups           []string                      `yaml:"security_groups"`
+	AssignPublicIP           *bool                         `yaml:"assign_public_ip"`
+	NetworkFromService       string                        `yaml:"network_from_service"`
+	Overrides                *OverridesYamlConfig          `yaml:"overrides"`
+
+	Hooks *HooksYamlConfig `yaml:"hooks"`
+}
+
+// ImagesYamlConfig is images updated together in a step, written as a list of [container=]repo:tag
+// or a map of container to repo:tag
+type ImagesYamlConfig []string
+
+func (ic *ImagesYamlConfig) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind != yaml.MappingNode {
+		return n.Decode((*[]string)(ic))
+	}
+	var m map[string]string
+	if err := n.Decode(&m); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		*ic = append(*ic, k+"="+m[k])
+	}
+	return nil
+}
+
+// OverridesYamlConfig overrides a container of one-shot tasks
+type OverridesYamlConfig struct {
+	// Container is required if the task definition has more than 1 container
+	Container   string            `yaml:"container"`
+	Command     []string          `yaml:"command"`
+	Environment map[string]string `yaml:"environment"`
+	Cpu         *int64            `yaml:"cpu"`
+	Memory      *int64            `yaml:"memory"`
+	TaskRoleArn string            `yaml:"task_role_arn"`
+}
+
+// taskOverride converts o for the containers in taskDef
+func (o *OverridesYamlConfig) taskOverride(taskDef *ecs.TaskDefinition) (*ecs.TaskOverride, error) {
+	container := o.Container
+	if container == "" {
+		if len(taskDef.ContainerDefinitions) != 1 {
+			return nil, fmt.Errorf("overrides.container is required for %s which has %d containers", *taskDef.Family, len(taskDef.ContainerDefinitions))
 		}
-		containers = append(containers, &cc)
+		container = *taskDef.ContainerDefinitions[0].Name
+	}
+	found := false
+	for _, c := range taskDef.ContainerDefinitions {
+		found = found || *c.Name == container
+	}
+	if !found {
+		return nil, fmt.Errorf("Not found container %s in %s", container, *taskDef.Family)
+	}
+	co := &ecs.ContainerOverride{
+		Name:   aws.String(container),
+		Cpu:    o.Cpu,
+		Memory: o.Memory,
+	}
+	if len(o.Command) > 0 {
+		co.Command = aws.StringSlice(o.Command)
+	}
+	keys := make([]string, 0, len(o.Environment))
+	for k := range o.Environment {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		co.Environment = append(co.Environment, &ecs.KeyValuePair{
+			Name:  aws.String(k),
+			Value: aws.String(o.Environment[k]),
+		})
+	}
+	to := &ecs.TaskOverride{
+		ContainerOverrides: []*ecs.ContainerOverride{co},
+	}
+	if o.TaskRoleArn != "" {
+		to.TaskRoleArn = aws.String(o.TaskRoleArn)
 	}
-	newTaskDef.ContainerDefinitions = containers
-	return &newTaskDef, nil
+	return to, nil
 }
 
-type DeployTaskYamlConfig struct {
-	Task    string `yaml:"task"`
-	Image   string `yaml:"image"`
-	Cluster string `yaml:"cluster"`
-	Service string `yaml:"service"`
+type CapacityProviderYamlConfig struct {
+	CapacityProvider string `yaml:"capacity_provider"`
+	Weight           int64  `yaml:"weight"`
+	Base             int64  `yaml:"base"`
+}
+
+// runTaskOptions converts RunTask settings of one-shot tasks
+func (v *DeployTaskYamlConfig) runTaskOptions() (svc.RunTaskOptions, *keyError) {
+	opts := svc.RunTaskOptions{
+		LaunchType:      v.LaunchType,
+		PlatformVersion: v.PlatformVersion,
+	}
+	if v.Service != "" {
+		if v.LaunchType != "" || len(v.CapacityProviderStrategy) > 0 || v.PlatformVersion != "" || len(v.Subnets) > 0 || len(v.SecurityGroups) > 0 || v.AssignPublicIP != nil || v.NetworkFromService != "" || v.Overrides != nil {
+			return opts, &keyError{key: "service", msg: "launch type, network settings and overrides are only for tasks without service"}
+		}
+		return opts, nil
+	}
+	if v.LaunchType != "" {
+		valid := false
+		for _, lt := range ecs.LaunchType_Values() {
+			valid = valid || lt == v.LaunchType
+		}
+		if !valid {
+			return opts, &keyError{key: "launch_type", msg: fmt.Sprintf("launch_type must be one of %s: %s", strings.Join(ecs.LaunchType_Values(), ", "), v.LaunchType)}
+		}
+		if len(v.CapacityProviderStrategy) > 0 {
+			return opts, &keyError{key: "capacity_provider_strategy", msg: "launch_type and capacity_provider_strategy are exclusive"}
+		}
+	}
+	for _, cp := range v.CapacityProviderStrategy {
+		if cp.CapacityProvider == "" {
+			return opts, &keyError{key: "capacity_provider_strategy", msg: "capacity_provider is required in capacity_provider_strategy"}
+		}
+		opts.CapacityProviderStrategy = append(opts.CapacityProviderStrategy, &ecs.CapacityProviderStrategyItem{
+			CapacityProvider: aws.String(cp.CapacityProvider),
+			Weight:           aws.Int64(cp.Weight),
+			Base:             aws.Int64(cp.Base),
+		})
+	}
+	if len(v.Subnets) > 0 || len(v.SecurityGroups) > 0 || v.AssignPublicIP != nil {
+		vpc := &ecs.AwsVpcConfiguration{
+			Subnets:        aws.StringSlice(v.Subnets),
+			SecurityGroups: aws.StringSlice(v.SecurityGroups),
+		}
+		if v.AssignPublicIP != nil {
+			vpc.AssignPublicIp = aws.String(ecs.AssignPublicIpDisabled)
+			if *v.AssignPublicIP {
+				vpc.AssignPublicIp = aws.String(ecs.AssignPublicIpEnabled)
+			}
+		}
+		opts.NetworkConfiguration = &ecs.NetworkConfiguration{AwsvpcConfiguration: vpc}
+	}
+	return opts, nil
 }
 
-func (sd *syncDeploy) parseYaml(path string) error {
-	buf, err := ioutil.ReadFile(path)
+func (sd *syncDeploy) parseYaml(t *configTree) error {
+	buf, err := t.bytes()
 	if err != nil {
 		return err
 	}
-	var ycs []*DeployTaskYamlConfig
-	if err = yaml.Unmarshal(buf, &ycs); err != nil {
+	sd.configHash = fmt.Sprintf("%x", sha256.Sum256(buf))
+	dts, hooks, err := parseDeployConfig(t)
+	if err != nil {
 		return err
 	}
-	dts := make([]*deployTask, 0)
-	for _, v := range ycs {
-		dt := &deployTask{}
-		if v.Cluster == "" {
-			return util.ErrorRed("cluster is required in yaml")
+	sd.deployTasks = dts
+	sd.hooks = hooks
+	return nil
+}
+
+// parseDeployConfig checks the config, which is a list of steps or a map of hooks and steps, and converts every step.
+// Unknown keys, values of wrong types and problems of every step are reported at once with their positions.
+func parseDeployConfig(t *configTree) ([]*deployTask, *HooksYamlConfig, error) {
+	var errs []string
+	report := func(n *yaml.Node, msg string) {
+		errs = append(errs, fmt.Sprintf("%s: %s", t.pos(n), msg))
+	}
+	var hooks *HooksYamlConfig
+	var steps []*yaml.Node
+	switch {
+	case t.root == nil:
+	case t.root.Kind == yaml.SequenceNode:
+		steps = t.root.Content
+	case t.root.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(t.root.Content); i += 2 {
+			k, v := t.root.Content[i], t.root.Content[i+1]
+			switch k.Value {
+			case "hooks":
+				nerrs := len(errs)
+				t.check(v, reflect.TypeOf(HooksYamlConfig{}), "hooks", report)
+				if len(errs) > nerrs {
+					continue
+				}
+				if err := v.Decode(&hooks); err != nil {
+					report(v, err.Error())
+					continue
+				}
+				for _, e := range hooks.validate() {
+					report(v, e)
+				}
+			case "steps":
+				if v.Kind != yaml.SequenceNode {
+					report(v, "steps must be a list")
+					continue
+				}
+				steps = v.Content
+			default:
+				report(k, fmt.Sprintf("unknown key %s", k.Value))
+			}
 		}
-		if v.Task == "" {
-			return util.ErrorRed("task is required in yaml")
+	default:
+		report(t.root, "config must be a list of steps or a map with steps")
+	}
+	var dts []*deployTask
+	seen := map[string]int{}
+	for i, n := range steps {
+		name := stepName(n, seen)
+		stepReport := func(at *yaml.Node, msg string) {
+			report(at, fmt.Sprintf("step %d (%s): %s", i+1, name, msg))
 		}
-		dt.cluster = v.Cluster
-		dt.service = v.Service
-		dt.taskDefinition = v.Task
-		img, err := toContainerImage(v.Image)
-		if err != nil {
-			return util.ErrorRed(fmt.Sprintf("Container name is invalid, %s", v.Image))
+		if n.ShortTag() == "!!null" {
+			stepReport(n, "empty step")
+			continue
+		}
+		if n.Kind != yaml.MappingNode {
+			stepReport(n, "step must be a map")
+			continue
+		}
+		nerrs := len(errs)
+		t.check(n, reflect.TypeOf(DeployTaskYamlConfig{}), "", stepReport)
+		if len(errs) > nerrs {
+			continue
+		}
+		var v DeployTaskYamlConfig
+		if err := n.Decode(&v); err != nil {
+			stepReport(n, err.Error())
+			continue
+		}
+		dt, kerrs := v.deployTask()
+		dt.name = name
+		dt.pos = t.pos(n)
+		for _, e := range kerrs {
+			at := n
+			if kv := mappingValue(n, e.key); kv != nil {
+				at = kv
+			}
+			stepReport(at, e.msg)
+		}
+		if v.DependsOn == nil && len(dts) > 0 {
+			dt.dependsOn = []string{dts[len(dts)-1].name}
 		}
-		dt.image = &img
 		dts = append(dts, dt)
 	}
-	sd.deployTasks = dts
-	return nil
+	if len(errs) > 0 {
+		return nil, nil, fmt.Errorf("%d error(s) in config:\n\t%s", len(errs), strings.Join(errs, "\n\t"))
+	}
+	return dts, hooks, nil
 }
 
-func (sd *syncDeploy) validateECRImage() error {
-	for _, v := range sd.deployTasks {
-		_, err := sd.ecrCli.FetchImageWithTag(v.image.name, v.image.tag)
+// defaultStepName is the name of the nth step of task without name: task, task#2, task#3...
+func defaultStepName(task string, n int) string {
+	if n <= 1 {
+		return task
+	}
+	return fmt.Sprintf("%s#%d", task, n)
+}
+
+// keyError is a problem of the value of key in a step, or of the step itself if key is empty
+type keyError struct {
+	key string
+	msg string
+}
+
+// deployTask converts v to deployTask and returns all problems of v
+func (v *DeployTaskYamlConfig) deployTask() (*deployTask, []keyError) {
+	var errs []keyError
+	fail := func(key, format string, a ...interface{}) {
+		errs = append(errs, keyError{key: key, msg: fmt.Sprintf(format, a...)})
+	}
+	dt := &deployTask{
+		labels:             v.Labels,
+		dependsOn:          v.DependsOn,
+		taskDefinition:     v.Task,
+		cluster:            v.Cluster,
+		service:            v.Service,
+		pinDigest:          v.Digest,
+		match:              v.Match,
+		autoRollback:       v.AutoRollback,
+		confirm:            v.Confirm,
+		run:                v.Run,
+		retries:            v.Retries,
+		networkFromService: v.NetworkFromService,
+		overrides:          v.Overrides,
+		hooks:              v.Hooks,
+	}
+	if dt.run == "" {
+		dt.run = stepRunAlways
+	}
+	if dt.run != stepRunAlways && dt.run != stepRunOnChange {
+		fail("run", "run must be %s or %s: %s", stepRunAlways, stepRunOnChange, v.Run)
+	}
+	if v.Cluster == "" {
+		fail("cluster", "cluster is required")
+	}
+	if v.Task == "" {
+		fail("task", "task is required")
+	}
+	if v.Match != "" {
+		if err := validateMatchStrategy(v.Match); err != nil {
+			fail("match", "%s", err)
+		}
+	}
+	var kerr *keyError
+	if dt.runTask, kerr = v.runTaskOptions(); kerr != nil {
+		errs = append(errs, *kerr)
+	}
+	for _, e := range v.Hooks.validate() {
+		fail("hooks", "%s", e)
+	}
+	for _, d := range []struct {
+		key   string
+		value string
+		dest  *time.Duration
+	}{
+		{"timeout", v.Timeout, &dt.timeout},
+		{"retry_backoff", v.RetryBackoff, &dt.retryBackoff},
+	} {
+		if d.value == "" {
+			continue
+		}
+		dur, err := time.ParseDuration(d.value)
+		if err != nil || dur <= 0 {
+			fail(d.key, "%s must be a positive duration like 30s or 10m: %s", d.key, d.value)
+			continue
+		}
+		*d.dest = dur
+	}
+	if v.Retries != nil && *v.Retries < 0 {
+		fail("retries", "retries must not be negative: %d", *v.Retries)
+	}
+	if v.Service == "" && v.AutoRollback {
+		fail("auto_rollback", "auto_rollback is only for steps with service")
+	}
+	images, imagesKey := v.Images, "images"
+	if v.Image != "" {
+		images, imagesKey = append([]string{v.Image}, images...), "image"
+	}
+	if v.Image != "" && len(v.Images) > 0 {
+		fail("images", "image and images are exclusive")
+	} else if len(images) == 0 {
+		fail("", "image or images is required")
+	}
+	containers := map[string]bool{}
+	for _, s := range images {
+		img, err := parseImageArg(s)
 		if err != nil {
-			return fmt.Errorf("Not found ecr image %s:%s", v.image.name, v.image.tag)
+			fail(imagesKey, "%s", err)
+			continue
+		}
+		if img.container != "" {
+			if containers[img.container] {
+				fail(imagesKey, "container %s is given more than 1 image", img.container)
+			}
+			containers[img.container] = true
+		}
+		dt.images = append(dt.images, img)
+	}
+	return dt, errs
+}
+
+func (sd *syncDeploy) validateImage() error {
+	for _, v := range sd.deployTasks {
+		for _, img := range v.images {
+			if _, err := resolveImage(sd.ecrCli, sd.regCli, &img, false); err != nil {
+				return fmt.Errorf("Not found image %s: %s", img.String(), err)
+			}
 		}
 	}
 	return nil
@@ -0,0 +1,388 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	yaml "gopkg.in/yaml.v3"
+
+	"github.com/urfave/cli"
+)
+
+// overlayRemoveKey in an overlay step removes the step from the base config
+const overlayRemoveKey = "remove"
+
+// configTree is the yaml tree of the sync-deploy config.
+// Nodes keep their positions in the files they are read from, which differ once an overlay is merged.
+type configTree struct {
+	// root is nil if the config is empty
+	root  *yaml.Node
+	files map[*yaml.Node]string
+}
+
+// pos is file:line:column of n for errors
+func (t *configTree) pos(n *yaml.Node) string {
+	return fmt.Sprintf("%s:%d:%d", t.files[n], n.Line, n.Column)
+}
+
+// bytes returns the config as yaml
+func (t *configTree) bytes() ([]byte, error) {
+	if t.root == nil {
+		return nil, nil
+	}
+	return yaml.Marshal(t.root)
+}
+
+// renderSyncDeployConfig returns the config of --path, merged with the overlay of --env and interpolated with variables
+func renderSyncDeployConfig(c *cli.Context) (*configTree, error) {
+	vars, err := loadVars(c)
+	if err != nil {
+		return nil, err
+	}
+	return loadConfigTree(c.String("path"), c.String("env"), vars)
+}
+
+// loadConfigTree reads the config of path merged with the overlay of env if env is given
+func loadConfigTree(path, env string, vars map[string]string) (*configTree, error) {
+	t := &configTree{files: map[*yaml.Node]string{}}
+	var err error
+	if t.root, err = t.read(path, vars); err != nil {
+		return nil, err
+	}
+	if env == "" {
+		return t, nil
+	}
+	overlay, err := t.read(overlayPath(path, env), vars)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.merge(overlay); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// read reads path interpolated with vars and returns the root node
+func (t *configTree) read(path string, vars map[string]string) (*yaml.Node, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(buf, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	if err := interpolate(&doc, vars); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+	t.setFile(doc.Content[0], path)
+	return doc.Content[0], nil
+}
+
+func (t *configTree) setFile(n *yaml.Node, path string) {
+	t.files[n] = path
+	for _, v := range n.Content {
+		t.setFile(v, path)
+	}
+}
+
+// merge merges the overlay into the config. Steps of the overlay are merged by mergeSteps
+// and the other settings are merged recursively.
+func (t *configTree) merge(overlay *yaml.Node) error {
+	bsteps, bm, err := t.splitConfig(t.root)
+	if err != nil {
+		return err
+	}
+	osteps, om, err := t.splitConfig(overlay)
+	if err != nil {
+		return err
+	}
+	if osteps != nil {
+		if bsteps == nil {
+			bsteps = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		}
+		if err := t.mergeSteps(bsteps, osteps); err != nil {
+			return err
+		}
+	}
+	if bm == nil && om == nil {
+		t.root = bsteps
+		return nil
+	}
+	if bm == nil {
+		bm = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	}
+	if om != nil {
+		bm = deepMerge(bm, om)
+	}
+	if bsteps != nil {
+		setMappingValue(bm, "steps", bsteps)
+	}
+	t.root = bm
+	return nil
+}
+
+// splitConfig returns steps and the other settings of the config, which is a list of steps or a map with steps
+func (t *configTree) splitConfig(root *yaml.Node) (*yaml.Node, *yaml.Node, error) {
+	if root == nil {
+		return nil, nil, nil
+	}
+	switch root.Kind {
+	case yaml.SequenceNode:
+		return root, nil, nil
+	case yaml.MappingNode:
+		rest := &yaml.Node{Kind: yaml.MappingNode, Tag: root.Tag, Line: root.Line, Column: root.Column}
+		t.files[rest] = t.files[root]
+		var steps *yaml.Node
+		for i := 0; i+1 < len(root.Content); i += 2 {
+			k, v := root.Content[i], root.Content[i+1]
+			if k.Value != "steps" {
+				rest.Content = append(rest.Content, k, v)
+				continue
+			}
+			if v.Kind != yaml.SequenceNode {
+				return nil, nil, fmt.Errorf("%s: steps must be a list", t.pos(v))
+			}
+			steps = v
+		}
+		return steps, rest, nil
+	}
+	return nil, nil, fmt.Errorf("%s: config must be a list of steps or a map with steps", t.pos(root))
+}
+
+// overlayPath returns syncdeploy.prod.yaml for syncdeploy.yaml and prod
+func overlayPath(path, env string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "." + env + ext
+}
+
+// mergeSteps deep-merges overlay steps into base steps with the same name.
+// Steps only in overlay are appended and steps with `remove: true` are removed.
+func (t *configTree) mergeSteps(base, overlay *yaml.Node) error {
+	seen := map[string]int{}
+	for _, om := range overlay.Content {
+		if om.Kind != yaml.MappingNode {
+			return fmt.Errorf("%s: step must be a map", t.pos(om))
+		}
+		name := stepName(om, seen)
+		if name == "" {
+			return fmt.Errorf("%s: name or task is required in overlay step", t.pos(om))
+		}
+		idx := -1
+		for i, n := range stepNames(base.Content) {
+			if n == name {
+				idx = i
+				break
+			}
+		}
+		remove := false
+		if i := mappingIndex(om, overlayRemoveKey); i >= 0 {
+			v := om.Content[i+1]
+			if v.ShortTag() != "!!bool" {
+				return fmt.Errorf("%s: remove must be true or false", t.pos(v))
+			}
+			remove = v.Value == "true"
+			om.Content = append(om.Content[:i:i], om.Content[i+2:]...)
+		}
+		if remove {
+			if idx < 0 {
+				return fmt.Errorf("%s: step %s to remove is not in the base config", t.pos(om), name)
+			}
+			base.Content = append(base.Content[:idx], base.Content[idx+1:]...)
+			continue
+		}
+		if idx < 0 {
+			base.Content = append(base.Content, om)
+			continue
+		}
+		base.Content[idx] = deepMerge(base.Content[idx], om)
+	}
+	return nil
+}
+
+// stepNames returns names of steps as in parseDeployConfig
+func stepNames(steps []*yaml.Node) []string {
+	seen := map[string]int{}
+	names := make([]string, len(steps))
+	for i, v := range steps {
+		names[i] = stepName(v, seen)
+	}
+	return names
+}
+
+// stepName is name of the step, or the default name by task if name is omitted.
+// seen counts steps without name of each task so far.
+func stepName(m *yaml.Node, seen map[string]int) string {
+	if name := scalarValue(mappingValue(m, "name")); name != "" {
+		return name
+	}
+	task := scalarValue(mappingValue(m, "task"))
+	if task == "" {
+		return ""
+	}
+	seen[task]++
+	return defaultStepName(task, seen[task])
+}
+
+// deepMerge merges maps recursively. Other values including lists in overlay replace base.
+func deepMerge(base, overlay *yaml.Node) *yaml.Node {
+	if base.Kind != yaml.MappingNode || overlay.Kind != yaml.MappingNode {
+		return overlay
+	}
+	for i := 0; i+1 < len(overlay.Content); i += 2 {
+		k, v := overlay.Content[i], overlay.Content[i+1]
+		if j := mappingIndex(base, k.Value); j >= 0 {
+			base.Content[j+1] = deepMerge(base.Content[j+1], v)
+		} else {
+			base.Content = append(base.Content, k, v)
+		}
+	}
+	return base
+}
+
+// mappingIndex returns the index of key in the content of the mapping m, or -1
+func mappingIndex(m *yaml.Node, key string) int {
+	if m == nil || m.Kind != yaml.MappingNode {
+		return -1
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// mappingValue returns the value of key in the mapping m, or nil
+func mappingValue(m *yaml.Node, key string) *yaml.Node {
+	if i := mappingIndex(m, key); i >= 0 {
+		return m.Content[i+1]
+	}
+	return nil
+}
+
+func setMappingValue(m *yaml.Node, key string, v *yaml.Node) {
+	if i := mappingIndex(m, key); i >= 0 {
+		m.Content[i+1] = v
+		return
+	}
+	m.Content = append(m.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, v)
+}
+
+func scalarValue(n *yaml.Node) string {
+	if n == nil || n.Kind != yaml.ScalarNode {
+		return ""
+	}
+	return n.Value
+}
+
+// check reports keys unknown to typ and values of the wrong type in n, the value of key.
+// Messages are in yaml keys rather than go types.
+func (t *configTree) check(n *yaml.Node, typ reflect.Type, key string, report func(*yaml.Node, string)) {
+	if n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if n.ShortTag() == "!!null" {
+		return
+	}
+	if typ == reflect.TypeOf(ImagesYamlConfig{}) {
+		// a list of images or a map of container to image
+		if n.Kind == yaml.MappingNode {
+			typ = reflect.TypeOf(map[string]string{})
+		} else {
+			typ = reflect.TypeOf([]string{})
+		}
+	}
+	switch typ.Kind() {
+	case reflect.Struct:
+		if n.Kind != yaml.MappingNode {
+			report(n, fmt.Sprintf("%s must be a map", key))
+			return
+		}
+		fields := yamlFields(typ)
+		keys := map[string]bool{}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k, v := n.Content[i], n.Content[i+1]
+			if k.ShortTag() == "!!merge" {
+				t.check(v, typ, key, report)
+				continue
+			}
+			if keys[k.Value] {
+				report(k, fmt.Sprintf("%s is given more than once", k.Value))
+				continue
+			}
+			keys[k.Value] = true
+			f, ok := fields[k.Value]
+			if !ok {
+				if key == "" {
+					report(k, fmt.Sprintf("unknown key %s", k.Value))
+				} else {
+					report(k, fmt.Sprintf("unknown key %s in %s", k.Value, key))
+				}
+				continue
+			}
+			name := k.Value
+			if key != "" {
+				name = key + "." + k.Value
+			}
+			t.check(v, f.Type, name, report)
+		}
+	case reflect.Slice:
+		if n.Kind != yaml.SequenceNode {
+			report(n, fmt.Sprintf("%s must be a list", key))
+			return
+		}
+		for _, v := range n.Content {
+			t.check(v, typ.Elem(), "each of "+key, report)
+		}
+	case reflect.Map:
+		if n.Kind != yaml.MappingNode {
+			report(n, fmt.Sprintf("%s must be a map", key))
+			return
+		}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			t.check(n.Content[i+1], typ.Elem(), key+"."+n.Content[i].Value, report)
+		}
+	case reflect.String:
+		if n.Kind != yaml.ScalarNode {
+			report(n, fmt.Sprintf("%s must be a string", key))
+		}
+	case reflect.Bool:
+		if n.ShortTag() != "!!bool" {
+			report(n, fmt.Sprintf("%s must be true or false: %s", key, n.Value))
+		}
+	case reflect.Int, reflect.Int64:
+		if n.ShortTag() != "!!int" {
+			report(n, fmt.Sprintf("%s must be an integer: %s", key, n.Value))
+		}
+	}
+}
+
+// yamlFields returns fields of the struct typ by yaml key, including fields of inlined structs
+func yamlFields(typ reflect.Type) map[string]reflect.StructField {
+	fields := map[string]reflect.StructField{}
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		tag := strings.Split(f.Tag.Get("yaml"), ",")
+		if len(tag) > 1 && tag[1] == "inline" {
+			for k, v := range yamlFields(f.Type) {
+				fields[k] = v
+			}
+			continue
+		}
+		if tag[0] == "" || tag[0] == "-" {
+			continue
+		}
+		fields[tag[0]] = f
+	}
+	return fields
+}
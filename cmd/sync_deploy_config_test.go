@@ -0,0 +1,234 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testSteps = `
+- task: migrate
+  cluster: c
+  image: app:v1
+- task: api
+  cluster: c
+  service: api
+  image: app:v1
+- task: migrate
+  cluster: c
+  image: app:v1
+- name: worker
+  task: migrate
+  cluster: c
+  image: app:v1
+`
+
+// writeConfig writes content to name in dir and returns the path
+func writeConfig(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func loadTestConfig(t *testing.T, base, overlay string) (*configTree, string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	path := writeConfig(t, dir, "syncdeploy.yaml", base)
+	env := ""
+	if overlay != "" {
+		env = "prod"
+		writeConfig(t, dir, "syncdeploy.prod.yaml", overlay)
+	}
+	tree, err := loadConfigTree(path, env, map[string]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree, path, overlayPath(path, "prod")
+}
+
+func TestParseDeployConfigDefaultNames(t *testing.T) {
+	tree, _, _ := loadTestConfig(t, testSteps, "")
+	dts, _, err := parseDeployConfig(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, dt := range dts {
+		names = append(names, dt.name)
+	}
+	want := []string{"migrate", "api", "migrate#2", "worker"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("names = %v, want %v", names, want)
+	}
+	if _, err := newStepGraph(dts); err != nil {
+		t.Errorf("newStepGraph: %s", err)
+	}
+}
+
+func TestParseDeployConfigDuplicatedName(t *testing.T) {
+	tree, _, _ := loadTestConfig(t, testSteps+`
+- name: api
+  task: api
+  cluster: c
+  image: app:v1
+`, "")
+	dts, _, err := parseDeployConfig(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newStepGraph(dts); err == nil {
+		t.Error("newStepGraph: want error of the duplicated name api")
+	}
+}
+
+func TestMergeStepsDefaultNames(t *testing.T) {
+	tree, _, _ := loadTestConfig(t, testSteps, `
+- name: migrate#2
+  cluster: prod
+- task: migrate
+  remove: true
+`)
+	steps := tree.root.Content
+	want := []string{"api", "migrate#2", "worker"}
+	if got := stepNames(steps); !reflect.DeepEqual(got, want) {
+		t.Errorf("names = %v, want %v", got, want)
+	}
+	if cluster := scalarValue(mappingValue(steps[1], "cluster")); cluster != "prod" {
+		t.Errorf("cluster of migrate#2 = %v, want prod", cluster)
+	}
+}
+
+func TestParseDeployConfigErrors(t *testing.T) {
+	tree, path, opath := loadTestConfig(t, `
+hooks:
+  before: ""
+steps:
+  - name: api
+    task: api
+    cluster: c
+    service: api
+    image: app:v1
+    sevice: typo
+  - name: worker
+    task: worker
+    image: app:v1
+    timeout: 1x
+    retries: many
+`, `
+steps:
+  - name: api
+    overrides:
+      command: [migrate]
+  - name: worker
+    run: sometimes
+    assign_public_ip: yes please
+`)
+	_, _, err := parseDeployConfig(tree)
+	if err == nil {
+		t.Fatal("want errors")
+	}
+	for _, want := range []string{
+		path + ":3:11: hooks.before must be a list",
+		path + ":10:5: step 1 (api): unknown key sevice",
+		path + ":15:14: step 2 (worker): retries must be an integer: many",
+		opath + ":8:23: step 2 (worker): assign_public_ip must be true or false: yes please",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("errors don't contain %q:\n%s", want, err)
+		}
+	}
+
+	// the steps with wrong types are fixed to report the others
+	tree, path, opath = loadTestConfig(t, `
+- name: api
+  task: api
+  cluster: c
+  service: api
+  image: app:v1
+- name: worker
+  task: worker
+  image: app:v1
+  timeout: 1x
+`, `
+- name: api
+  overrides:
+    command: [migrate]
+- name: worker
+  run: sometimes
+`)
+	_, _, err = parseDeployConfig(tree)
+	if err == nil {
+		t.Fatal("want errors")
+	}
+	for _, want := range []string{
+		"4 error(s) in config",
+		path + ":5:12: step 1 (api): launch type, network settings and overrides are only for tasks without service",
+		opath + ":6:8: step 2 (worker): run must be always or on_change: sometimes",
+		path + ":7:3: step 2 (worker): cluster is required",
+		path + ":10:12: step 2 (worker): timeout must be a positive duration like 30s or 10m: 1x",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("errors don't contain %q:\n%s", want, err)
+		}
+	}
+}
+
+func TestRenderKeepsValues(t *testing.T) {
+	dir := t.TempDir()
+	path := writeConfig(t, dir, "syncdeploy.yaml", `
+# comment of ${UNDEFINED}
+- name: api
+  task: api
+  cluster: ${CLUSTER}
+  image: app:${TAG}
+  hooks:
+    before:
+      - echo ${COMMENT}
+  retries: ${RETRIES}
+`)
+	tree, err := loadConfigTree(path, "", map[string]string{
+		"CLUSTER": "a: b",
+		"TAG":     "v2",
+		"COMMENT": "done # oops",
+		"RETRIES": "2",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dts, _, err := parseDeployConfig(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dts[0].cluster != "a: b" {
+		t.Errorf("cluster = %q, want %q", dts[0].cluster, "a: b")
+	}
+	if *dts[0].retries != 2 {
+		t.Errorf("retries = %d, want 2", *dts[0].retries)
+	}
+	buf, err := tree.bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the rendered config is parsed to the same values
+	rendered := writeConfig(t, dir, "rendered.yaml", string(buf))
+	rtree, err := loadConfigTree(rendered, "", map[string]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rdts, _, err := parseDeployConfig(rtree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rdts[0].pos = dts[0].pos
+	if !reflect.DeepEqual(rdts, dts) {
+		t.Errorf("rendered config is parsed to %+v, want %+v\n%s", rdts[0], dts[0], buf)
+	}
+	if got := dts[0].hooks.Before[0]; got != "echo done # oops" {
+		t.Errorf("hook = %q, want %q", got, "echo done # oops")
+	}
+}
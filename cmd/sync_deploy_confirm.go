@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/atsushi-ishibashi/influencer/util"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// confirmStep asks on the terminal whether to deploy dt after printing the diff of ltd and ntd.
+// It fails closed if stdin is not a terminal, unless --yes is given.
+func (sd *syncDeploy) confirmStep(dt *deployTask, ltd, ntd *ecs.TaskDefinition) error {
+	if !sd.confirmEach && !dt.confirm {
+		return nil
+	}
+	sd.confirmMu.Lock()
+	defer sd.confirmMu.Unlock()
+	if ltd != nil && ntd != nil {
+		util.PdiffTaskDef(ntd.String(), ltd.String())
+	}
+	if sd.yes {
+		util.PrintlnYellow(fmt.Sprintf("%s: approved by --yes", dt.name))
+		return nil
+	}
+	if !isTerminal(os.Stdin) {
+		return fmt.Errorf("%s needs confirmation but stdin is not a terminal. approve with --yes", dt.name)
+	}
+	fmt.Printf("Deploy %s? [y/N]: ", dt.name)
+	ans, err := sd.stdin.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	switch strings.ToLower(strings.TrimSpace(ans)) {
+	case "y", "yes":
+		return nil
+	}
+	return fmt.Errorf("%s was not approved", dt.name)
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
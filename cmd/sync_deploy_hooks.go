@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/atsushi-ishibashi/influencer/util"
+)
+
+// HooksYamlConfig is local commands run around a step or the whole run.
+// Each command runs with `sh -c` and INFLUENCER_* environment variables,
+// which are read as $INFLUENCER_STEP since ${NAME} is interpolated in the config.
+type HooksYamlConfig struct {
+	Before    []string `yaml:"before"`
+	After     []string `yaml:"after"`
+	OnFailure []string `yaml:"on_failure"`
+}
+
+func (h *HooksYamlConfig) validate() []string {
+	if h == nil {
+		return nil
+	}
+	var errs []string
+	for _, v := range []struct {
+		key  string
+		cmds []string
+	}{
+		{"before", h.Before},
+		{"after", h.After},
+		{"on_failure", h.OnFailure},
+	} {
+		for _, c := range v.cmds {
+			if strings.TrimSpace(c) == "" {
+				errs = append(errs, fmt.Sprintf("hooks.%s has an empty command", v.key))
+				break
+			}
+		}
+	}
+	return errs
+}
+
+func (h *HooksYamlConfig) before() []string {
+	if h == nil {
+		return nil
+	}
+	return h.Before
+}
+
+func (h *HooksYamlConfig) after() []string {
+	if h == nil {
+		return nil
+	}
+	return h.After
+}
+
+func (h *HooksYamlConfig) onFailure() []string {
+	if h == nil {
+		return nil
+	}
+	return h.OnFailure
+}
+
+// runHooks runs cmds in order and stops at the first failure
+func runHooks(label string, cmds []string, env map[string]string) error {
+	environ := os.Environ()
+	for k, v := range env {
+		environ = append(environ, k+"="+v)
+	}
+	for _, c := range cmds {
+		util.PrintlnYellow(fmt.Sprintf("%s: %s", label, c))
+		cmd := exec.Command("sh", "-c", c)
+		cmd.Env = environ
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("%s hook `%s` failed: %s", label, c, err)
+		}
+	}
+	return nil
+}
+
+// runFailureHooks runs on_failure hooks with INFLUENCER_ERROR. Their failures are only printed.
+func runFailureHooks(label string, cmds []string, env map[string]string, cause error) {
+	if len(cmds) == 0 {
+		return
+	}
+	env["INFLUENCER_ERROR"] = cause.Error()
+	if err := runHooks(label, cmds, env); err != nil {
+		util.PrintlnRed(err.Error())
+	}
+}
+
+// stepHookEnv is the metadata of dt exposed to its hooks
+func (sd *syncDeploy) stepHookEnv(dt *deployTask) map[string]string {
+	images := make([]string, 0, len(dt.images))
+	for _, img := range dt.images {
+		images = append(images, img.String())
+	}
+	env := sd.runHookEnv()
+	env["INFLUENCER_STEP"] = dt.name
+	env["INFLUENCER_CLUSTER"] = dt.cluster
+	env["INFLUENCER_SERVICE"] = dt.service
+	env["INFLUENCER_TASK"] = dt.taskDefinition
+	env["INFLUENCER_IMAGES"] = strings.Join(images, " ")
+	if sd.state != nil {
+		env["INFLUENCER_TASK_DEFINITION_ARN"] = sd.state.registered(dt.name)
+	}
+	return env
+}
+
+// runHookEnv is the metadata of the run exposed to all hooks
+func (sd *syncDeploy) runHookEnv() map[string]string {
+	env := map[string]string{}
+	if sd.state != nil {
+		env["INFLUENCER_CONFIG"] = sd.state.ConfigPath
+		env["INFLUENCER_STATE"] = sd.state.path
+	}
+	return env
+}
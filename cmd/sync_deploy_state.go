@@ -0,0 +1,146 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// outcomes of steps in runState
+const (
+	stepStatePending   = "pending"
+	stepStateSucceeded = "succeeded"
+	stepStateFailed    = "failed"
+	// stepStateSkipped is a step out of the selection, which doesn't run on --resume either
+	stepStateSkipped = "skipped"
+)
+
+// runState is persisted after every change so that a failed sync-deploy run can be resumed
+type runState struct {
+	ConfigPath string                `json:"config_path"`
+	ConfigHash string                `json:"config_hash"`
+	StartedAt  time.Time             `json:"started_at"`
+	Selection  *stepSelection        `json:"selection,omitempty"`
+	Steps      map[string]*stepState `json:"steps"`
+
+	path string
+	mu   sync.Mutex
+}
+
+type stepState struct {
+	Status            string    `json:"status"`
+	TaskDefinitionArn string    `json:"task_definition_arn,omitempty"`
+	Error             string    `json:"error,omitempty"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+// newRunState records steps out of selected as skipped
+func newRunState(path, configPath, configHash string, tasks []*deployTask, sel *stepSelection, selected map[string]bool) *runState {
+	rs := &runState{
+		ConfigPath: configPath,
+		ConfigHash: configHash,
+		StartedAt:  time.Now(),
+		Steps:      map[string]*stepState{},
+		path:       path,
+	}
+	if !sel.isEmpty() {
+		rs.Selection = sel
+	}
+	for _, dt := range tasks {
+		status := stepStatePending
+		if !selected[dt.name] {
+			status = stepStateSkipped
+		}
+		rs.Steps[dt.name] = &stepState{Status: status, UpdatedAt: rs.StartedAt}
+	}
+	return rs
+}
+
+// loadRunState reads the state at path and checks it was written for the same config
+func loadRunState(path, configHash string, tasks []*deployTask) (*runState, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	rs := &runState{}
+	if err := json.Unmarshal(buf, rs); err != nil {
+		return nil, fmt.Errorf("state file %s is broken: %s", path, err)
+	}
+	if rs.ConfigHash != configHash {
+		return nil, fmt.Errorf("config has changed since the run recorded in %s", path)
+	}
+	rs.path = path
+	for _, dt := range tasks {
+		if _, ok := rs.Steps[dt.name]; !ok {
+			return nil, fmt.Errorf("step %s is not in %s", dt.name, path)
+		}
+	}
+	return rs, nil
+}
+
+// succeededSteps returns names of steps which don't have to run again
+func (rs *runState) succeededSteps() map[string]bool {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	done := map[string]bool{}
+	for k, v := range rs.Steps {
+		if v.Status == stepStateSucceeded {
+			done[k] = true
+		}
+	}
+	return done
+}
+
+func (rs *runState) registered(name string) string {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return rs.Steps[name].TaskDefinitionArn
+}
+
+func (rs *runState) setRegistered(name, taskDefArn string) error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.Steps[name].TaskDefinitionArn = taskDefArn
+	rs.Steps[name].UpdatedAt = time.Now()
+	return rs.save()
+}
+
+func (rs *runState) setResult(name string, err error) error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	st := rs.Steps[name]
+	st.Status = stepStateSucceeded
+	st.Error = ""
+	if err != nil {
+		st.Status = stepStateFailed
+		st.Error = err.Error()
+	}
+	st.UpdatedAt = time.Now()
+	return rs.save()
+}
+
+// save writes the state atomically. rs.mu must be held.
+func (rs *runState) save() error {
+	buf, err := json.MarshalIndent(rs, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(rs.path), filepath.Base(rs.path))
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(buf); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), rs.path)
+}
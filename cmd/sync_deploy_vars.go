@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v3"
+
+	"github.com/urfave/cli"
+)
+
+// varPattern matches ${NAME}, and $$ as an escaped $
+var varPattern = regexp.MustCompile(`\$\$|\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// loadVars merges environment variables, --vars-file and --var in ascending order of precedence
+func loadVars(c *cli.Context) (map[string]string, error) {
+	vars := map[string]string{}
+	for _, v := range os.Environ() {
+		if i := strings.Index(v, "="); i > 0 {
+			vars[v[:i]] = v[i+1:]
+		}
+	}
+	if path := c.String("vars-file"); path != "" {
+		buf, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var fileVars map[string]string
+		if err := yaml.Unmarshal(buf, &fileVars); err != nil {
+			return nil, fmt.Errorf("vars file %s is invalid: %s", path, err)
+		}
+		for k, v := range fileVars {
+			vars[k] = v
+		}
+	}
+	for _, v := range c.StringSlice("var") {
+		i := strings.Index(v, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("--var must be key=value: %s", v)
+		}
+		vars[v[:i]] = v[i+1:]
+	}
+	return vars, nil
+}
+
+// interpolate replaces ${NAME} in string values of the yaml tree n with vars. It fails if any variable is undefined.
+// Keys and comments are left as they are, and a value can't change the structure of the config
+// because it is replaced after parsing.
+func interpolate(n *yaml.Node, vars map[string]string) error {
+	undefined := map[string]bool{}
+	interpolateNode(n, vars, undefined)
+	if len(undefined) > 0 {
+		names := make([]string, 0, len(undefined))
+		for k := range undefined {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("undefined variables: %s", strings.Join(names, ", "))
+	}
+	return nil
+}
+
+func interpolateNode(n *yaml.Node, vars map[string]string, undefined map[string]bool) {
+	switch n.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, v := range n.Content {
+			interpolateNode(v, vars, undefined)
+		}
+	case yaml.MappingNode:
+		for i := 1; i < len(n.Content); i += 2 {
+			interpolateNode(n.Content[i], vars, undefined)
+		}
+	case yaml.ScalarNode:
+		if n.ShortTag() != "!!str" {
+			return
+		}
+		value := varPattern.ReplaceAllStringFunc(n.Value, func(m string) string {
+			if m == "$$" {
+				return "$"
+			}
+			name := m[2 : len(m)-1]
+			v, ok := vars[name]
+			if !ok {
+				undefined[name] = true
+				return m
+			}
+			return v
+		})
+		if value == n.Value {
+			return
+		}
+		n.Value = value
+		// a plain value without tag is typed by what it becomes, e.g. retries: ${RETRIES} is a number
+		if n.Style == 0 {
+			n.Tag = ""
+		}
+	}
+}
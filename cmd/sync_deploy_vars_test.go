@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+func TestInterpolate(t *testing.T) {
+	vars := map[string]string{
+		"TAG":     "v1",
+		"COMMENT": "v2 # oops",
+		"COLON":   "a: b",
+		"LINES":   "v3\ncluster: other",
+		"N":       "3",
+	}
+	cases := []struct {
+		name string
+		in   string
+		want interface{}
+		err  bool
+	}{
+		{name: "value", in: "image: app:${TAG}", want: map[string]interface{}{"image": "app:v1"}},
+		{name: "escaped", in: "run: echo $${HOME} $$INFLUENCER_STEP", want: map[string]interface{}{"run": "echo ${HOME} $INFLUENCER_STEP"}},
+		{name: "brace-less", in: "run: echo $INFLUENCER_STEP", want: map[string]interface{}{"run": "echo $INFLUENCER_STEP"}},
+		{name: "undefined", in: "cluster: ${UNDEFINED}", err: true},
+		{name: "comment", in: "# set ${UNDEFINED} with --var\ncluster: c # or ${UNDEFINED}", want: map[string]interface{}{"cluster": "c"}},
+		{name: "key", in: "${TAG}: x", want: map[string]interface{}{"${TAG}": "x"}},
+		{name: "hash in value", in: "image: app:${COMMENT}\ncluster: c", want: map[string]interface{}{"image": "app:v2 # oops", "cluster": "c"}},
+		{name: "colon in value", in: "cluster: ${COLON}", want: map[string]interface{}{"cluster": "a: b"}},
+		{name: "newline in value", in: "image: app:${LINES}\ncluster: c", want: map[string]interface{}{"image": "app:v3\ncluster: other", "cluster": "c"}},
+		{name: "list", in: "- ${COLON}\n- ${COMMENT}", want: []interface{}{"a: b", "v2 # oops"}},
+		{name: "block scalar", in: "run: |\n  echo ${COMMENT}\n  echo ${LINES}\n", want: map[string]interface{}{"run": "echo v2 # oops\necho v3\ncluster: other\n"}},
+		{name: "integer", in: "retries: ${N}", want: map[string]interface{}{"retries": 3}},
+		{name: "quoted integer", in: "retries: '${N}'", want: map[string]interface{}{"retries": "3"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var n yaml.Node
+			if err := yaml.Unmarshal([]byte(tc.in), &n); err != nil {
+				t.Fatal(err)
+			}
+			err := interpolate(&n, vars)
+			if tc.err {
+				if err == nil {
+					t.Error("want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got interface{}
+			if err := n.Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %#v, want %#v", got, tc.want)
+			}
+
+			// the rendered yaml keeps the values
+			buf, err := yaml.Marshal(&n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var rendered interface{}
+			if err := yaml.Unmarshal(buf, &rendered); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(rendered, tc.want) {
+				t.Errorf("rendered %#v, want %#v", rendered, tc.want)
+			}
+		})
+	}
+}
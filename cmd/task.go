@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/atsushi-ishibashi/influencer/svc"
+	"github.com/atsushi-ishibashi/influencer/util"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// inheritNetworkConfiguration fills network configuration of opts with that of service.
+// Subnets, security groups and public ip setting in opts take precedence.
+func inheritNetworkConfiguration(ecsCli *svc.EcsClient, cluster, service string, opts svc.RunTaskOptions) (svc.RunTaskOptions, error) {
+	serv, err := ecsCli.FetchService(cluster, service)
+	if err != nil {
+		return opts, err
+	}
+	if serv.NetworkConfiguration == nil || serv.NetworkConfiguration.AwsvpcConfiguration == nil {
+		return opts, fmt.Errorf("Service %s has no awsvpc network configuration", service)
+	}
+	inherited := *serv.NetworkConfiguration.AwsvpcConfiguration
+	if opts.NetworkConfiguration != nil {
+		vpc := opts.NetworkConfiguration.AwsvpcConfiguration
+		if len(vpc.Subnets) > 0 {
+			inherited.Subnets = vpc.Subnets
+		}
+		if len(vpc.SecurityGroups) > 0 {
+			inherited.SecurityGroups = vpc.SecurityGroups
+		}
+		if vpc.AssignPublicIp != nil {
+			inherited.AssignPublicIp = vpc.AssignPublicIp
+		}
+	}
+	opts.NetworkConfiguration = &ecs.NetworkConfiguration{AwsvpcConfiguration: &inherited}
+	return opts, nil
+}
+
+// waitTasksStop waits until tasks of taskDef stop, streaming their logs if logs is true
+func waitTasksStop(sess *session.Session, ecsCli *svc.EcsClient, cluster string, taskDef *ecs.TaskDefinition, taskARNs []*string, timeout time.Duration, logs bool) error {
+	if !logs {
+		return ecsCli.WaitUntilTasksStop(cluster, taskARNs, timeout)
+	}
+	var streams []taskLogStream
+	for _, v := range taskARNs {
+		streams = append(streams, taskLogStreams(taskDef, *v)...)
+	}
+	stopLogs := make(chan struct{})
+	logsDone := streamLogs(sess, streams, stopLogs)
+	err := ecsCli.WaitUntilTasksStop(cluster, taskARNs, timeout)
+	close(stopLogs)
+	<-logsDone
+	return err
+}
+
+// checkTasksExit fails if an essential or overridden container of the stopped tasks didn't exit with 0.
+// It returns the first non-zero exit code, or 1 if the failed container has no exit code.
+// Exits of the other containers, e.g. sidecars stopped with 137 or 143, are only printed.
+func checkTasksExit(ecsCli *svc.EcsClient, cluster string, taskDef *ecs.TaskDefinition, taskARNs []*string) (int, error) {
+	res, err := ecsCli.WatchTasks(cluster, taskARNs)
+	if err != nil {
+		return 1, err
+	}
+	if len(res.Failures) > 0 {
+		return 1, fmt.Errorf("%s", res.Failures)
+	}
+	code := 0
+	var failed []string
+	for _, t := range res.Tasks {
+		tfailed, others, tcode := taskExits(taskDef, t)
+		for _, msg := range others {
+			util.PrintlnYellow("\t" + msg)
+		}
+		for _, msg := range tfailed {
+			util.PrintlnRed("\t" + msg)
+		}
+		failed = append(failed, tfailed...)
+		if code == 0 {
+			code = tcode
+		}
+	}
+	if len(failed) > 0 {
+		return code, fmt.Errorf("%d container(s) failed: %s", len(failed), strings.Join(failed, "; "))
+	}
+	return 0, nil
+}
+
+// taskExits returns how the containers of t which decide its result failed, their first non-zero exit code,
+// and how the other containers exited
+func taskExits(taskDef *ecs.TaskDefinition, t *ecs.Task) ([]string, []string, int) {
+	code := 0
+	var failed, others []string
+	for _, c := range t.Containers {
+		msg := containerExit(t, c)
+		if !decidesExit(taskDef, t, aws.StringValue(c.Name)) {
+			others = append(others, msg+" (not essential)")
+			continue
+		}
+		if c.ExitCode != nil && *c.ExitCode == 0 {
+			continue
+		}
+		failed = append(failed, msg)
+		if code == 0 {
+			code = 1
+			if c.ExitCode != nil {
+				code = int(*c.ExitCode)
+			}
+		}
+	}
+	return failed, others, code
+}
+
+// decidesExit reports whether the container name decides the result of t:
+// it is essential in taskDef, or its settings are overridden for t
+func decidesExit(taskDef *ecs.TaskDefinition, t *ecs.Task, name string) bool {
+	if t.Overrides != nil {
+		for _, o := range t.Overrides.ContainerOverrides {
+			if aws.StringValue(o.Name) == name {
+				return true
+			}
+		}
+	}
+	for _, cd := range taskDef.ContainerDefinitions {
+		if aws.StringValue(cd.Name) == name {
+			return cd.Essential == nil || *cd.Essential
+		}
+	}
+	return true
+}
+
+// containerExit describes the exit code and reasons of c of t
+func containerExit(t *ecs.Task, c *ecs.Container) string {
+	msg := fmt.Sprintf("container %s of task %s", aws.StringValue(c.Name), aws.StringValue(t.TaskArn))
+	if c.ExitCode != nil {
+		msg += fmt.Sprintf(" exited with %d", *c.ExitCode)
+	} else {
+		msg += " has no exit code"
+	}
+	if c.Reason != nil {
+		msg += fmt.Sprintf(", reason: %s", *c.Reason)
+	}
+	if t.StoppedReason != nil {
+		msg += fmt.Sprintf(", stopped reason: %s", *t.StoppedReason)
+	}
+	return msg
+}
@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/atsushi-ishibashi/influencer/svc"
+	"github.com/atsushi-ishibashi/influencer/util"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/urfave/cli"
+)
+
+func NewValidateCommand(out, errOut io.Writer) cli.Command {
+	return cli.Command{
+		Name:  "validate",
+		Usage: "Validate sync-deploy config without deploying",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "path",
+				Usage: "path to yaml deploy config file",
+			},
+			cli.StringFlag{
+				Name:  "env",
+				Usage: "environment of the overlay, e.g. prod for syncdeploy.prod.yaml",
+			},
+			cli.StringSliceFlag{
+				Name:  "var",
+				Usage: "variable key=value for ${key} in the config, more than 1",
+			},
+			cli.StringFlag{
+				Name:  "vars-file",
+				Usage: "path to yaml file of variables",
+			},
+			cli.BoolFlag{
+				Name:  "remote",
+				Usage: "also check clusters, services, task definition families and images exist",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if c.String("path") == "" {
+				return util.ErrorRed("--path is required")
+			}
+			t, err := renderSyncDeployConfig(c)
+			if err != nil {
+				return util.ErrorRed(err.Error())
+			}
+			dts, _, err := parseDeployConfig(t)
+			if err != nil {
+				return util.ErrorRed(err.Error())
+			}
+			if _, err := newStepGraph(dts); err != nil {
+				return util.ErrorRed(err.Error())
+			}
+			if c.Bool("remote") {
+				if err := util.ConfigAWS(c); err != nil {
+					return util.ErrorRed(err.Error())
+				}
+				if err := validateRemote(dts); err != nil {
+					return util.ErrorRed(err.Error())
+				}
+			}
+			util.PrintlnGreen(fmt.Sprintf("%s is valid: %d step(s)", c.String("path"), len(dts)))
+			return nil
+		},
+	}
+}
+
+// validateRemote checks resources referred by dts exist and reports all missing ones
+func validateRemote(dts []*deployTask) error {
+	awsregion := os.Getenv("AWS_DEFAULT_REGION")
+	sess, err := session.NewSession()
+	if err != nil {
+		return err
+	}
+	ecsCli := &svc.EcsClient{ECS: ecs.New(sess, &aws.Config{
+		Region: aws.String(awsregion),
+	})}
+	ecrCli := &svc.EcrClient{ECR: ecr.New(sess, &aws.Config{
+		Region: aws.String(awsregion),
+	})}
+	regCli := svc.NewRegistryClient()
+
+	var errs []string
+	clusters := map[string]error{}
+	for i, dt := range dts {
+		fail := func(err error) {
+			errs = append(errs, fmt.Sprintf("%s: step %d (%s): %s", dt.pos, i+1, dt.name, err))
+		}
+		cerr, ok := clusters[dt.cluster]
+		if !ok {
+			cerr = checkCluster(ecsCli, dt.cluster)
+			clusters[dt.cluster] = cerr
+		}
+		if cerr != nil {
+			fail(cerr)
+		} else if dt.service != "" {
+			if err := checkService(ecsCli, dt.cluster, dt.service); err != nil {
+				fail(err)
+			}
+		}
+		if _, err := ecsCli.FetchLatestTaskDefinition(dt.taskDefinition); err != nil {
+			fail(err)
+		}
+		for _, img := range dt.images {
+			if _, err := resolveImage(ecrCli, regCli, &img, false); err != nil {
+				fail(fmt.Errorf("Not found image %s: %s", img.String(), err))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%d error(s) in remote resources:\n\t%s", len(errs), strings.Join(errs, "\n\t"))
+	}
+	return nil
+}
+
+func checkCluster(ecsCli *svc.EcsClient, cluster string) error {
+	cl, err := ecsCli.FetchCluster(cluster)
+	if err != nil {
+		return err
+	}
+	if *cl.Status != "ACTIVE" {
+		return fmt.Errorf("cluster %s is %s", cluster, *cl.Status)
+	}
+	return nil
+}
+
+func checkService(ecsCli *svc.EcsClient, cluster, service string) error {
+	serv, err := ecsCli.FetchService(cluster, service)
+	if err != nil {
+		return err
+	}
+	if *serv.Status != "ACTIVE" {
+		return fmt.Errorf("service %s is %s", service, *serv.Status)
+	}
+	return nil
+}
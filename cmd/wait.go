@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/atsushi-ishibashi/influencer/svc"
+	"github.com/atsushi-ishibashi/influencer/util"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+const (
+	defaultWaitTimeout  = 10 * time.Minute
+	defaultRetryBackoff = 30 * time.Second
+	// stopTasksTimeout covers the stop timeout of containers, 2 minutes at most
+	stopTasksTimeout = 5 * time.Minute
+)
+
+// waitServiceUpdate waits until service is stable, printing deployments and events after since
+func waitServiceUpdate(ecsCli *svc.EcsClient, cluster, service string, since time.Time, timeout time.Duration) error {
+	lastDeployments := ""
+	seenEvents := map[string]bool{}
+	return ecsCli.WaitUntilServiceUpdateWithProgress(cluster, service, timeout, func(s *ecs.Service) {
+		var lines []string
+		for _, d := range s.Deployments {
+			lines = append(lines, fmt.Sprintf("\t%s %s running: %d, pending: %d, desired: %d", aws.StringValue(d.Status), svc.TaskDefinitionName(aws.StringValue(d.TaskDefinition)), aws.Int64Value(d.RunningCount), aws.Int64Value(d.PendingCount), aws.Int64Value(d.DesiredCount)))
+		}
+		if ds := strings.Join(lines, "\n"); ds != lastDeployments {
+			fmt.Println(ds)
+			lastDeployments = ds
+		}
+		// events are sorted newest first
+		for i := len(s.Events) - 1; i >= 0; i-- {
+			e := s.Events[i]
+			if seenEvents[aws.StringValue(e.Id)] || aws.TimeValue(e.CreatedAt).Before(since) {
+				continue
+			}
+			seenEvents[aws.StringValue(e.Id)] = true
+			util.PrintlnYellow(fmt.Sprintf("\t%s %s", aws.TimeValue(e.CreatedAt).Format(time.RFC3339), aws.StringValue(e.Message)))
+		}
+	})
+}
+
+// waitServiceUpdateOrRollback waits like waitServiceUpdate. On failure with autoRollback,
+// it restores the task definition of prev, the service before the update, and waits again.
+func waitServiceUpdateOrRollback(ecsCli *svc.EcsClient, prev *ecs.Service, cluster, service string, since time.Time, timeout time.Duration, autoRollback bool) error {
+	err := waitServiceUpdate(ecsCli, cluster, service, since, timeout)
+	if err == nil || !autoRollback {
+		return err
+	}
+	prevTaskDef := svc.TaskDefinitionName(aws.StringValue(prev.TaskDefinition))
+	util.PrintlnRed(fmt.Sprintf("\tFailed to update %s: %s", service, err))
+	util.PrintlnYellow(fmt.Sprintf("\tRolling back %s to %s...", service, prevTaskDef))
+	since = time.Now()
+	if _, rerr := ecsCli.UpdateServiceWithTaskDef(prev, &ecs.TaskDefinition{TaskDefinitionArn: prev.TaskDefinition}); rerr != nil {
+		return fmt.Errorf("%s, and rollback to %s failed: %s", err, prevTaskDef, rerr)
+	}
+	if rerr := waitServiceUpdate(ecsCli, cluster, service, since, timeout); rerr != nil {
+		return fmt.Errorf("%s, and rollback to %s failed: %s", err, prevTaskDef, rerr)
+	}
+	util.PrintlnYellow(fmt.Sprintf("\tRolled back %s to %s", service, prevTaskDef))
+	return fmt.Errorf("%s, rolled back to %s", err, prevTaskDef)
+}
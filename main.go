@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"os"
 
 	"github.com/atsushi-ishibashi/influencer/cmd"
@@ -28,10 +29,21 @@ func main() {
 
 	planCommand := cmd.NewPlanCommand(os.Stdout, os.Stderr)
 	syncDeployCommand := cmd.NewSyncDeployCommand(os.Stdout, os.Stderr)
+	rollbackCommand := cmd.NewRollbackCommand(os.Stdout, os.Stderr)
+	runCommand := cmd.NewRunCommand(os.Stdout, os.Stderr)
+	renderCommand := cmd.NewRenderCommand(os.Stdout, os.Stderr)
+	validateCommand := cmd.NewValidateCommand(os.Stdout, os.Stderr)
 
 	app.Commands = []cli.Command{
 		planCommand,
 		syncDeployCommand,
+		rollbackCommand,
+		runCommand,
+		renderCommand,
+		validateCommand,
+	}
+	if err := app.Run(os.Args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
-	app.Run(os.Args)
 }
@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ecr"
 )
 
@@ -11,7 +12,22 @@ type EcrClient struct {
 	*ecr.ECR
 }
 
-func (ec *EcrClient) FetchImageWithTag(repo, tag string) (*ecr.Image, error) {
+// ForRegion returns a client for ECR in region with the same credentials
+func (ec *EcrClient) ForRegion(region string) (*EcrClient, error) {
+	if aws.StringValue(ec.Client.Config.Region) == region {
+		return ec, nil
+	}
+	sess, err := session.NewSession(ec.Client.Config.Copy(&aws.Config{
+		Region: aws.String(region),
+	}))
+	if err != nil {
+		return nil, err
+	}
+	return &EcrClient{ECR: ecr.New(sess)}, nil
+}
+
+// FetchImageWithTag fetches the image of repo in the registry of registryID, or the current account if empty
+func (ec *EcrClient) FetchImageWithTag(registryID, repo, tag string) (*ecr.Image, error) {
 	input := &ecr.BatchGetImageInput{
 		ImageIds: []*ecr.ImageIdentifier{
 			{
@@ -25,6 +41,9 @@ func (ec *EcrClient) FetchImageWithTag(repo, tag string) (*ecr.Image, error) {
 			aws.String("application/vnd.oci.image.manifest.v1+json"),
 		},
 	}
+	if registryID != "" {
+		input.RegistryId = aws.String(registryID)
+	}
 	result, err := ec.BatchGetImage(input)
 	if err != nil {
 		return nil, err
@@ -34,3 +53,30 @@ func (ec *EcrClient) FetchImageWithTag(repo, tag string) (*ecr.Image, error) {
 	}
 	return result.Images[0], nil
 }
+
+func (ec *EcrClient) FetchImageWithDigest(registryID, repo, digest string) (*ecr.Image, error) {
+	input := &ecr.BatchGetImageInput{
+		ImageIds: []*ecr.ImageIdentifier{
+			{
+				ImageDigest: aws.String(digest),
+			},
+		},
+		RepositoryName: aws.String(repo),
+		AcceptedMediaTypes: []*string{
+			aws.String("application/vnd.docker.distribution.manifest.v1+json"),
+			aws.String("application/vnd.docker.distribution.manifest.v2+json"),
+			aws.String("application/vnd.oci.image.manifest.v1+json"),
+		},
+	}
+	if registryID != "" {
+		input.RegistryId = aws.String(registryID)
+	}
+	result, err := ec.BatchGetImage(input)
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Images) == 0 {
+		return nil, fmt.Errorf("Not Found Image repo: %s, digest: %s", repo, digest)
+	}
+	return result.Images[0], nil
+}
@@ -1,12 +1,28 @@
 package svc
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ecs"
 )
 
+const (
+	serviceWaiterDelay = 15 * time.Second
+	taskWaiterDelay    = 6 * time.Second
+	// taskMissingReason is the failure reason of DescribeTasks for tasks not found
+	taskMissingReason = "MISSING"
+
+	awsReservedTagPrefix = "aws:"
+)
+
+// ErrWaitTimeout is wrapped by errors of waits which timed out
+var ErrWaitTimeout = errors.New("Timed out")
+
 type EcsClient struct {
 	*ecs.ECS
 }
@@ -22,6 +38,20 @@ func (ec *EcsClient) FetchTaskDefinition(taskDefName string) (*ecs.TaskDefinitio
 	return result.TaskDefinition, nil
 }
 
+func (ec *EcsClient) FetchTaskDefinitionTags(taskDefName string) ([]*ecs.Tag, error) {
+	input := &ecs.DescribeTaskDefinitionInput{
+		TaskDefinition: aws.String(taskDefName),
+		Include: []*string{
+			aws.String(ecs.TaskDefinitionFieldTags),
+		},
+	}
+	result, err := ec.DescribeTaskDefinition(input)
+	if err != nil {
+		return nil, err
+	}
+	return result.Tags, nil
+}
+
 func (ec *EcsClient) FetchLatestTaskDefinition(familyName string) (*ecs.TaskDefinition, error) {
 	input := &ecs.ListTaskDefinitionsInput{
 		FamilyPrefix: aws.String(familyName),
@@ -45,6 +75,22 @@ func (ec *EcsClient) FetchLatestTaskDefinition(familyName string) (*ecs.TaskDefi
 	return descResult.TaskDefinition, nil
 }
 
+func (ec *EcsClient) FetchCluster(cluster string) (*ecs.Cluster, error) {
+	input := &ecs.DescribeClustersInput{
+		Clusters: []*string{
+			aws.String(cluster),
+		},
+	}
+	result, err := ec.DescribeClusters(input)
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Clusters) == 0 {
+		return nil, fmt.Errorf("Not Found Cluster: %s", cluster)
+	}
+	return result.Clusters[0], nil
+}
+
 func (ec *EcsClient) FetchService(cluster, service string) (*ecs.Service, error) {
 	input := &ecs.DescribeServicesInput{
 		Cluster: aws.String(cluster),
@@ -62,15 +108,18 @@ func (ec *EcsClient) FetchService(cluster, service string) (*ecs.Service, error)
 	return result.Services[0], nil
 }
 
+// RegisterTaskDefinition registers a new revision copied from taskDef.
+// Tags of the source revision (taskDef.TaskDefinitionArn) are carried over.
 func (ec *EcsClient) RegisterTaskDefinition(taskDef *ecs.TaskDefinition) (*ecs.TaskDefinition, error) {
-	input := &ecs.RegisterTaskDefinitionInput{
-		ContainerDefinitions: taskDef.ContainerDefinitions,
-		Family:               taskDef.Family,
-		NetworkMode:          taskDef.NetworkMode,
-		PlacementConstraints: taskDef.PlacementConstraints,
-		TaskRoleArn:          taskDef.TaskRoleArn,
-		Volumes:              taskDef.Volumes,
+	var tags []*ecs.Tag
+	if taskDef.TaskDefinitionArn != nil {
+		t, err := ec.FetchTaskDefinitionTags(*taskDef.TaskDefinitionArn)
+		if err != nil {
+			return nil, err
+		}
+		tags = t
 	}
+	input := NewRegisterTaskDefinitionInput(taskDef, tags)
 	res, err := ec.ECS.RegisterTaskDefinition(input)
 	if err != nil {
 		return nil, err
@@ -95,11 +144,39 @@ func (ec *EcsClient) UpdateServiceWithTaskDef(service *ecs.Service, taskDef *ecs
 	return result.Service, nil
 }
 
-func (ec *EcsClient) WaitUntilTasksStop(taskARNs []*string) error {
+// WaitUntilTasksStop waits until all tasks stop or timeout passes
+func (ec *EcsClient) WaitUntilTasksStop(cluster string, taskARNs []*string, timeout time.Duration) error {
 	input := &ecs.DescribeTasksInput{
-		Tasks: taskARNs,
+		Cluster: aws.String(cluster),
+		Tasks:   taskARNs,
+	}
+	ctx, cancel := context.WithTimeout(aws.BackgroundContext(), timeout)
+	defer cancel()
+	err := pollUntil(ctx, taskWaiterDelay, func(ctx context.Context) (bool, error) {
+		result, err := ec.DescribeTasksWithContext(ctx, input)
+		if err != nil {
+			return false, err
+		}
+		for _, f := range result.Failures {
+			if aws.StringValue(f.Reason) != taskMissingReason {
+				return false, fmt.Errorf("%s", result.Failures)
+			}
+		}
+		// tasks just started can be MISSING for a while until they are visible
+		if len(result.Failures) > 0 {
+			return false, nil
+		}
+		for _, t := range result.Tasks {
+			if aws.StringValue(t.LastStatus) != ecs.DesiredStatusStopped {
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("%w after %s waiting until tasks stop", ErrWaitTimeout, timeout)
 	}
-	return ec.WaitUntilTasksStopped(input)
+	return err
 }
 
 func (ec *EcsClient) WaitUntilServiceUpdate(cluster, service string) error {
@@ -112,17 +189,193 @@ func (ec *EcsClient) WaitUntilServiceUpdate(cluster, service string) error {
 	return ec.WaitUntilServicesStable(input)
 }
 
-func (ec *EcsClient) InvokeTask(cluster string, taskDef *ecs.TaskDefinition) (*ecs.RunTaskOutput, error) {
+// WaitUntilServiceUpdateWithProgress is WaitUntilServiceUpdate with timeout, which keeps polling on throttling.
+// progress is called with the service on every poll. It fails as soon as the primary deployment's rollout fails.
+func (ec *EcsClient) WaitUntilServiceUpdateWithProgress(cluster, service string, timeout time.Duration, progress func(*ecs.Service)) error {
+	input := &ecs.DescribeServicesInput{
+		Cluster: aws.String(cluster),
+		Services: []*string{
+			aws.String(service),
+		},
+	}
+	ctx, cancel := context.WithTimeout(aws.BackgroundContext(), timeout)
+	defer cancel()
+	err := pollUntil(ctx, serviceWaiterDelay, func(ctx context.Context) (bool, error) {
+		result, err := ec.DescribeServicesWithContext(ctx, input)
+		if err != nil {
+			return false, err
+		}
+		if len(result.Services) == 0 {
+			return false, fmt.Errorf("Not Found Service: %s", service)
+		}
+		serv := result.Services[0]
+		if progress != nil {
+			progress(serv)
+		}
+		for _, d := range serv.Deployments {
+			if aws.StringValue(d.Status) == "PRIMARY" && aws.StringValue(d.RolloutState) == ecs.DeploymentRolloutStateFailed {
+				return false, fmt.Errorf("Deployment %s of %s failed: %s", aws.StringValue(d.Id), service, aws.StringValue(d.RolloutStateReason))
+			}
+		}
+		if status := aws.StringValue(serv.Status); status == "DRAINING" || status == "INACTIVE" {
+			return false, fmt.Errorf("Service %s is %s", service, status)
+		}
+		return len(serv.Deployments) == 1 && aws.Int64Value(serv.RunningCount) == aws.Int64Value(serv.DesiredCount), nil
+	})
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("%w after %s waiting until %s is stable", ErrWaitTimeout, timeout, service)
+	}
+	return err
+}
+
+// RunTaskOptions are optional parameters of InvokeTask
+type RunTaskOptions struct {
+	LaunchType               string
+	CapacityProviderStrategy []*ecs.CapacityProviderStrategyItem
+	PlatformVersion          string
+	NetworkConfiguration     *ecs.NetworkConfiguration
+	Overrides                *ecs.TaskOverride
+}
+
+func (ec *EcsClient) InvokeTask(cluster string, taskDef *ecs.TaskDefinition, opts RunTaskOptions) (*ecs.RunTaskOutput, error) {
 	input := &ecs.RunTaskInput{
-		Cluster:        aws.String(cluster),
-		TaskDefinition: taskDef.TaskDefinitionArn,
+		Cluster:                  aws.String(cluster),
+		TaskDefinition:           taskDef.TaskDefinitionArn,
+		CapacityProviderStrategy: opts.CapacityProviderStrategy,
+		NetworkConfiguration:     opts.NetworkConfiguration,
+		Overrides:                opts.Overrides,
+	}
+	if opts.LaunchType != "" {
+		input.LaunchType = aws.String(opts.LaunchType)
+	}
+	if opts.PlatformVersion != "" {
+		input.PlatformVersion = aws.String(opts.PlatformVersion)
 	}
 	return ec.RunTask(input)
 }
 
-func (ec *EcsClient) WatchTasks(taskARNs []*string) (*ecs.DescribeTasksOutput, error) {
+// FetchStoppedTasks returns tasks of family which stopped recently. ECS keeps stopped tasks for about an hour.
+func (ec *EcsClient) FetchStoppedTasks(cluster, family string) ([]*ecs.Task, error) {
+	input := &ecs.ListTasksInput{
+		Cluster:       aws.String(cluster),
+		Family:        aws.String(family),
+		DesiredStatus: aws.String(ecs.DesiredStatusStopped),
+	}
+	result, err := ec.ListTasks(input)
+	if err != nil {
+		return nil, err
+	}
+	if len(result.TaskArns) == 0 {
+		return nil, nil
+	}
+	tasks, err := ec.WatchTasks(cluster, result.TaskArns)
+	if err != nil {
+		return nil, err
+	}
+	return tasks.Tasks, nil
+}
+
+// FetchServiceTasks returns running tasks of service
+func (ec *EcsClient) FetchServiceTasks(cluster, service string) ([]*ecs.Task, error) {
+	input := &ecs.ListTasksInput{
+		Cluster:       aws.String(cluster),
+		ServiceName:   aws.String(service),
+		DesiredStatus: aws.String(ecs.DesiredStatusRunning),
+	}
+	result, err := ec.ListTasks(input)
+	if err != nil {
+		return nil, err
+	}
+	if len(result.TaskArns) == 0 {
+		return nil, nil
+	}
+	tasks, err := ec.WatchTasks(cluster, result.TaskArns)
+	if err != nil {
+		return nil, err
+	}
+	return tasks.Tasks, nil
+}
+
+// StopTasks requests ECS to stop tasks. It doesn't wait until they stop.
+func (ec *EcsClient) StopTasks(cluster string, taskARNs []*string, reason string) error {
+	for _, v := range taskARNs {
+		input := &ecs.StopTaskInput{
+			Cluster: aws.String(cluster),
+			Task:    v,
+			Reason:  aws.String(reason),
+		}
+		if _, err := ec.StopTask(input); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ec *EcsClient) WatchTasks(cluster string, taskARNs []*string) (*ecs.DescribeTasksOutput, error) {
 	input := &ecs.DescribeTasksInput{
-		Tasks: taskARNs,
+		Cluster: aws.String(cluster),
+		Tasks:   taskARNs,
 	}
 	return ec.DescribeTasks(input)
 }
+
+// NewRegisterTaskDefinitionInput builds a RegisterTaskDefinitionInput which is a faithful copy of taskDef
+func NewRegisterTaskDefinitionInput(taskDef *ecs.TaskDefinition, tags []*ecs.Tag) *ecs.RegisterTaskDefinitionInput {
+	input := &ecs.RegisterTaskDefinitionInput{
+		ContainerDefinitions:    taskDef.ContainerDefinitions,
+		Cpu:                     taskDef.Cpu,
+		EphemeralStorage:        taskDef.EphemeralStorage,
+		ExecutionRoleArn:        taskDef.ExecutionRoleArn,
+		Family:                  taskDef.Family,
+		InferenceAccelerators:   taskDef.InferenceAccelerators,
+		IpcMode:                 taskDef.IpcMode,
+		Memory:                  taskDef.Memory,
+		NetworkMode:             taskDef.NetworkMode,
+		PidMode:                 taskDef.PidMode,
+		PlacementConstraints:    taskDef.PlacementConstraints,
+		ProxyConfiguration:      taskDef.ProxyConfiguration,
+		RequiresCompatibilities: taskDef.RequiresCompatibilities,
+		RuntimePlatform:         taskDef.RuntimePlatform,
+		TaskRoleArn:             taskDef.TaskRoleArn,
+		Volumes:                 taskDef.Volumes,
+	}
+	for _, t := range tags {
+		// aws: prefixed tags, e.g. aws:cloudformation:stack-name, are reserved and rejected on register
+		if strings.HasPrefix(aws.StringValue(t.Key), awsReservedTagPrefix) {
+			continue
+		}
+		input.Tags = append(input.Tags, t)
+	}
+	return input
+}
+
+// FetchActiveTaskDefinitionArns returns ARNs of ACTIVE revisions in familyName, newest first
+func (ec *EcsClient) FetchActiveTaskDefinitionArns(familyName string) ([]*string, error) {
+	input := &ecs.ListTaskDefinitionsInput{
+		FamilyPrefix: aws.String(familyName),
+		Status:       aws.String(ecs.TaskDefinitionStatusActive),
+		Sort:         aws.String("DESC"),
+	}
+	arns := make([]*string, 0)
+	err := ec.ListTaskDefinitionsPages(input, func(page *ecs.ListTaskDefinitionsOutput, lastPage bool) bool {
+		for _, v := range page.TaskDefinitionArns {
+			// FamilyPrefix also matches other families which start with familyName
+			if strings.HasPrefix(TaskDefinitionName(*v), familyName+":") {
+				arns = append(arns, v)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(arns) == 0 {
+		return nil, fmt.Errorf("Not found active task definitions of %s", familyName)
+	}
+	return arns, nil
+}
+
+// TaskDefinitionName returns "family:revision" of a task definition ARN
+func TaskDefinitionName(taskDefArn string) string {
+	return taskDefArn[strings.LastIndex(taskDefArn, "/")+1:]
+}
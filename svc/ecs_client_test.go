@@ -0,0 +1,108 @@
+package svc
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func fullTaskDefinition() *ecs.TaskDefinition {
+	return &ecs.TaskDefinition{
+		TaskDefinitionArn: aws.String("arn:aws:ecs:ap-northeast-1:123456789012:task-definition/app:3"),
+		Revision:          aws.Int64(3),
+		Status:            aws.String(ecs.TaskDefinitionStatusActive),
+		ContainerDefinitions: []*ecs.ContainerDefinition{
+			{Name: aws.String("app"), Image: aws.String("app:v1")},
+		},
+		Cpu:                   aws.String("256"),
+		EphemeralStorage:      &ecs.EphemeralStorage{SizeInGiB: aws.Int64(30)},
+		ExecutionRoleArn:      aws.String("arn:aws:iam::123456789012:role/exec"),
+		Family:                aws.String("app"),
+		InferenceAccelerators: []*ecs.InferenceAccelerator{{DeviceName: aws.String("d"), DeviceType: aws.String("eia2.medium")}},
+		IpcMode:               aws.String(ecs.IpcModeTask),
+		Memory:                aws.String("512"),
+		NetworkMode:           aws.String(ecs.NetworkModeAwsvpc),
+		PidMode:               aws.String(ecs.PidModeTask),
+		PlacementConstraints: []*ecs.TaskDefinitionPlacementConstraint{
+			{Type: aws.String(ecs.TaskDefinitionPlacementConstraintTypeMemberOf), Expression: aws.String("attribute:ecs.availability-zone in [ap-northeast-1a]")},
+		},
+		ProxyConfiguration:      &ecs.ProxyConfiguration{ContainerName: aws.String("envoy"), Type: aws.String(ecs.ProxyConfigurationTypeAppmesh)},
+		RequiresCompatibilities: aws.StringSlice([]string{ecs.CompatibilityFargate}),
+		RuntimePlatform:         &ecs.RuntimePlatform{CpuArchitecture: aws.String(ecs.CPUArchitectureArm64), OperatingSystemFamily: aws.String(ecs.OSFamilyLinux)},
+		TaskRoleArn:             aws.String("arn:aws:iam::123456789012:role/task"),
+		Volumes:                 []*ecs.Volume{{Name: aws.String("data")}},
+	}
+}
+
+func TestNewRegisterTaskDefinitionInput(t *testing.T) {
+	td := fullTaskDefinition()
+	tags := []*ecs.Tag{
+		{Key: aws.String("team"), Value: aws.String("web")},
+		{Key: aws.String("aws:cloudformation:stack-name"), Value: aws.String("app")},
+		{Key: aws.String("aws:cloudformation:logical-id"), Value: aws.String("TaskDef")},
+		{Key: aws.String("env"), Value: aws.String("prod")},
+	}
+	input := NewRegisterTaskDefinitionInput(td, tags)
+
+	for name, got := range map[string]interface{}{
+		"ContainerDefinitions":    input.ContainerDefinitions,
+		"Cpu":                     input.Cpu,
+		"EphemeralStorage":        input.EphemeralStorage,
+		"ExecutionRoleArn":        input.ExecutionRoleArn,
+		"Family":                  input.Family,
+		"InferenceAccelerators":   input.InferenceAccelerators,
+		"IpcMode":                 input.IpcMode,
+		"Memory":                  input.Memory,
+		"NetworkMode":             input.NetworkMode,
+		"PidMode":                 input.PidMode,
+		"PlacementConstraints":    input.PlacementConstraints,
+		"ProxyConfiguration":      input.ProxyConfiguration,
+		"RequiresCompatibilities": input.RequiresCompatibilities,
+		"RuntimePlatform":         input.RuntimePlatform,
+		"TaskRoleArn":             input.TaskRoleArn,
+		"Volumes":                 input.Volumes,
+	} {
+		want := reflect.ValueOf(td).Elem().FieldByName(name).Interface()
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s = %v, want %v", name, got, want)
+		}
+	}
+
+	wantTags := []*ecs.Tag{tags[0], tags[3]}
+	if !reflect.DeepEqual(input.Tags, wantTags) {
+		t.Errorf("Tags = %v, want %v", input.Tags, wantTags)
+	}
+	if err := input.Validate(); err != nil {
+		t.Errorf("input is invalid: %s", err)
+	}
+}
+
+// TestNewRegisterTaskDefinitionInputCoversAllFields fails when the SDK adds a field to
+// RegisterTaskDefinitionInput which exists in TaskDefinition but is not copied
+func TestNewRegisterTaskDefinitionInputCoversAllFields(t *testing.T) {
+	input := reflect.ValueOf(NewRegisterTaskDefinitionInput(fullTaskDefinition(), nil)).Elem()
+	tdType := reflect.TypeOf(ecs.TaskDefinition{})
+	for i := 0; i < input.NumField(); i++ {
+		f := input.Type().Field(i)
+		if f.PkgPath != "" || f.Name == "Tags" {
+			continue
+		}
+		if _, ok := tdType.FieldByName(f.Name); !ok {
+			continue
+		}
+		if input.Field(i).IsNil() {
+			t.Errorf("%s is not copied", f.Name)
+		}
+	}
+}
+
+func TestNewRegisterTaskDefinitionInputWithoutTags(t *testing.T) {
+	input := NewRegisterTaskDefinitionInput(fullTaskDefinition(), []*ecs.Tag{
+		{Key: aws.String("aws:cloudformation:stack-name"), Value: aws.String("app")},
+	})
+	if input.Tags != nil {
+		t.Errorf("Tags = %v, want nil", input.Tags)
+	}
+}
@@ -0,0 +1,15 @@
+package svc
+
+import (
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// IsRetryableError reports whether err is throttling or another error which may succeed if retried
+func IsRetryableError(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		return false
+	}
+	return request.IsErrorThrottle(aerr) || request.IsErrorRetryable(aerr)
+}
@@ -0,0 +1,30 @@
+package svc
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+type LogsClient struct {
+	*cloudwatchlogs.CloudWatchLogs
+}
+
+// FetchLogEvents returns events after token and the token for the next call.
+// It returns no events if the stream is not created yet.
+func (lc *LogsClient) FetchLogEvents(group, stream string, token *string) ([]*cloudwatchlogs.OutputLogEvent, *string, error) {
+	input := &cloudwatchlogs.GetLogEventsInput{
+		LogGroupName:  aws.String(group),
+		LogStreamName: aws.String(stream),
+		NextToken:     token,
+		StartFromHead: aws.Bool(true),
+	}
+	result, err := lc.GetLogEvents(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == cloudwatchlogs.ErrCodeResourceNotFoundException {
+			return nil, token, nil
+		}
+		return nil, token, err
+	}
+	return result.Events, result.NextForwardToken, nil
+}
@@ -0,0 +1,235 @@
+package svc
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	dockerHubHost     = "docker.io"
+	dockerHubAPIHost  = "registry-1.docker.io"
+	dockerHubAuthKey  = "https://index.docker.io/v1/"
+	dockerConfigEnv   = "DOCKER_CONFIG"
+	dockerConfigFile  = "config.json"
+	contentDigestHead = "Docker-Content-Digest"
+)
+
+var manifestMediaTypes = []string{
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.v1+prettyjws",
+}
+
+// RegistryClient talks to Docker Hub, GHCR or any registry which implements the Docker Registry HTTP API v2
+type RegistryClient struct {
+	HTTPClient *http.Client
+	// auths maps registry host to base64 encoded "user:password"
+	auths map[string]string
+}
+
+// NewRegistryClient returns RegistryClient with credentials in ~/.docker/config.json if exists
+func NewRegistryClient() *RegistryClient {
+	rc := &RegistryClient{
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+		auths:      map[string]string{},
+	}
+	dir := os.Getenv(dockerConfigEnv)
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return rc
+		}
+		dir = filepath.Join(home, ".docker")
+	}
+	buf, err := ioutil.ReadFile(filepath.Join(dir, dockerConfigFile))
+	if err != nil {
+		return rc
+	}
+	var conf struct {
+		Auths map[string]struct {
+			Auth string `json:"auth"`
+		} `json:"auths"`
+	}
+	if err := json.Unmarshal(buf, &conf); err != nil {
+		return rc
+	}
+	for k, v := range conf.Auths {
+		if v.Auth == "" {
+			continue
+		}
+		host := k
+		if k == dockerHubAuthKey {
+			host = dockerHubHost
+		}
+		host = strings.TrimPrefix(strings.TrimPrefix(host, "https://"), "http://")
+		rc.auths[strings.TrimSuffix(host, "/")] = v.Auth
+	}
+	return rc
+}
+
+// FetchDigest resolves reference (tag or digest) of host/repo to the manifest digest
+func (rc *RegistryClient) FetchDigest(host, repo, reference string) (string, error) {
+	apiHost := host
+	if host == dockerHubHost || host == "index.docker.io" {
+		apiHost = dockerHubAPIHost
+		if !strings.Contains(repo, "/") {
+			repo = "library/" + repo
+		}
+	}
+	u := fmt.Sprintf("https://%s/v2/%s/manifests/%s", apiHost, repo, reference)
+	res, err := rc.requestManifest(http.MethodHead, u, "")
+	if err != nil {
+		return "", err
+	}
+	res.Body.Close()
+	authz := ""
+	if res.StatusCode == http.StatusUnauthorized {
+		authz, err = rc.authorize(res.Header.Get("WWW-Authenticate"), host)
+		if err != nil {
+			return "", err
+		}
+		res, err = rc.requestManifest(http.MethodHead, u, authz)
+		if err != nil {
+			return "", err
+		}
+		res.Body.Close()
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Not Found Image %s/%s:%s (status %d)", host, repo, reference, res.StatusCode)
+	}
+	if d := res.Header.Get(contentDigestHead); d != "" {
+		return d, nil
+	}
+	return rc.digestFromBody(u, authz)
+}
+
+func (rc *RegistryClient) requestManifest(method, u, authz string) (*http.Response, error) {
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+	if authz != "" {
+		req.Header.Set("Authorization", authz)
+	}
+	return rc.HTTPClient.Do(req)
+}
+
+// digestFromBody calculates digest from the manifest for registries which don't return Docker-Content-Digest
+func (rc *RegistryClient) digestFromBody(u, authz string) (string, error) {
+	res, err := rc.requestManifest(http.MethodGet, u, authz)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Failed to fetch manifest %s (status %d)", u, res.StatusCode)
+	}
+	if d := res.Header.Get(contentDigestHead); d != "" {
+		return d, nil
+	}
+	buf, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(buf)), nil
+}
+
+// authorize answers the challenge in WWW-Authenticate and returns the value of Authorization header
+func (rc *RegistryClient) authorize(challenge, host string) (string, error) {
+	basic := rc.auths[host]
+	scheme, params := parseChallenge(challenge)
+	switch scheme {
+	case "basic":
+		if basic == "" {
+			return "", fmt.Errorf("No credentials for %s", host)
+		}
+		return "Basic " + basic, nil
+	case "bearer":
+	default:
+		return "", fmt.Errorf("Unsupported auth challenge from %s: %s", host, challenge)
+	}
+	tu, err := url.Parse(params["realm"])
+	if err != nil {
+		return "", err
+	}
+	q := tu.Query()
+	if params["service"] != "" {
+		q.Set("service", params["service"])
+	}
+	if params["scope"] != "" {
+		q.Set("scope", params["scope"])
+	}
+	tu.RawQuery = q.Encode()
+	req, err := http.NewRequest(http.MethodGet, tu.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if basic != "" {
+		req.Header.Set("Authorization", "Basic "+basic)
+	}
+	res, err := rc.HTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Failed to get token from %s (status %d)", tu.Host, res.StatusCode)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	return "Bearer " + token.Token, nil
+}
+
+// parseChallenge parses `Bearer realm="...",service="...",scope="..."`
+func parseChallenge(challenge string) (string, map[string]string) {
+	params := map[string]string{}
+	sl := strings.SplitN(strings.TrimSpace(challenge), " ", 2)
+	scheme := strings.ToLower(sl[0])
+	if len(sl) < 2 {
+		return scheme, params
+	}
+	rest := sl[1]
+	for rest != "" {
+		eq := strings.Index(rest, "=")
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(rest[:eq]))
+		rest = rest[eq+1:]
+		var val string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				val, rest = rest[1:], ""
+			} else {
+				val, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else if comma := strings.Index(rest, ","); comma >= 0 {
+			val, rest = rest[:comma], rest[comma:]
+		} else {
+			val, rest = rest, ""
+		}
+		params[key] = val
+		rest = strings.TrimLeft(rest, ", ")
+	}
+	return scheme, params
+}
@@ -0,0 +1,26 @@
+package svc
+
+import (
+	"context"
+	"time"
+)
+
+// pollUntil calls check every delay until it returns true or a non-retryable error, or ctx is done.
+// Unlike SDK waiters, throttling and other retryable errors don't stop polling,
+// so that a long wait is not killed by a single throttled API call.
+func pollUntil(ctx context.Context, delay time.Duration, check func(context.Context) (bool, error)) error {
+	for {
+		done, err := check(ctx)
+		if err != nil && !IsRetryableError(err) {
+			return err
+		}
+		if err == nil && done {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines around changes in hunks
+const diffContext = 3
+
+type diffOp struct {
+	kind byte // ' ', '-' or '+'
+	line string
+}
+
+// diffLines returns the edit script from previous to target based on their longest common subsequence.
+// Deletions come before insertions in each change.
+func diffLines(previous, target []string) []diffOp {
+	n, m := len(previous), len(target)
+	// lcs[i][j] is the length of the LCS of previous[i:] and target[j:]
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if previous[i] == target[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	ops := make([]diffOp, 0, n+m)
+	i, j := 0, 0
+	for i < n || j < m {
+		switch {
+		case i < n && j < m && previous[i] == target[j]:
+			ops = append(ops, diffOp{' ', previous[i]})
+			i++
+			j++
+		case i < n && (j == m || lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, diffOp{'-', previous[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', target[j]})
+			j++
+		}
+	}
+	return ops
+}
+
+// UnifiedDiff returns hunks of the unified diff from previous to target with diffContext lines of context.
+// It returns an empty string if they are the same.
+func UnifiedDiff(previous, target string) string {
+	ops := diffLines(strings.Split(previous, "\n"), strings.Split(target, "\n"))
+	var b strings.Builder
+	// oldLine and newLine are the line numbers of ops[k] before and after the change
+	oldLine, newLine := 1, 1
+	for k := 0; k < len(ops); {
+		if ops[k].kind == ' ' {
+			oldLine++
+			newLine++
+			k++
+			continue
+		}
+		start := k - diffContext
+		if start < 0 {
+			start = 0
+		}
+		// extend the hunk while the next change is within 2*diffContext unchanged lines
+		end, equals := k, 0
+		for i := k; i < len(ops) && equals <= 2*diffContext; i++ {
+			if ops[i].kind == ' ' {
+				equals++
+				continue
+			}
+			end, equals = i+1, 0
+		}
+		if end+diffContext < len(ops) {
+			end += diffContext
+		} else {
+			end = len(ops)
+		}
+		oldStart, newStart := oldLine-(k-start), newLine-(k-start)
+		oldCount, newCount := 0, 0
+		for _, op := range ops[start:end] {
+			if op.kind != '+' {
+				oldCount++
+			}
+			if op.kind != '-' {
+				newCount++
+			}
+		}
+		fmt.Fprintf(&b, "@@ -%s +%s @@\n", hunkRange(oldStart, oldCount), hunkRange(newStart, newCount))
+		for _, op := range ops[start:end] {
+			b.WriteByte(op.kind)
+			b.WriteString(op.line)
+			b.WriteByte('\n')
+		}
+		for _, op := range ops[k:end] {
+			if op.kind != '+' {
+				oldLine++
+			}
+			if op.kind != '-' {
+				newLine++
+			}
+		}
+		k = end
+	}
+	return b.String()
+}
+
+// hunkRange formats the range of a hunk header. An empty range starts at the line before it.
+func hunkRange(start, count int) string {
+	if count == 0 {
+		start--
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
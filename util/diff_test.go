@@ -0,0 +1,100 @@
+package util
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update golden files in testdata")
+
+// taskDefLines is a String() dump of a task definition shortened for tests
+var taskDefLines = []string{
+	"{",
+	"  ContainerDefinitions: [{",
+	"      Cpu: 256,",
+	"      Environment: [{",
+	"          Name: \"RAILS_ENV\",",
+	"          Value: \"production\"",
+	"        },{",
+	"          Name: \"TZ\",",
+	"          Value: \"Asia/Tokyo\"",
+	"        }],",
+	"      Essential: true,",
+	"      Image: \"123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/app:v1\",",
+	"      Memory: 512,",
+	"      Name: \"app\"",
+	"    }],",
+	"  Family: \"app\",",
+	"  NetworkMode: \"awsvpc\",",
+	"  Revision: 3,",
+	"  Status: \"ACTIVE\"",
+	"}",
+}
+
+// edit returns lines with lines[from:to] replaced with repl
+func edit(lines []string, from, to int, repl ...string) []string {
+	out := append([]string{}, lines[:from]...)
+	out = append(out, repl...)
+	return append(out, lines[to:]...)
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	previous := strings.Join(taskDefLines, "\n")
+	cases := []struct {
+		name   string
+		target string
+	}{
+		{
+			name: "insert_env_in_middle",
+			target: strings.Join(edit(taskDefLines, 7, 7,
+				"          Name: \"NEW_RELIC_APP\",",
+				"          Value: \"app\"",
+				"        },{",
+			), "\n"),
+		},
+		{
+			name:   "trailing_additions",
+			target: previous + "\n{\n  Tags: []\n}",
+		},
+		{
+			name:   "delete_at_start",
+			target: strings.Join(edit(taskDefLines, 0, 2), "\n"),
+		},
+		{
+			// the changes are 6 (2*context) unchanged lines apart and make one hunk
+			name: "merged_hunks",
+			target: strings.Join(edit(edit(taskDefLines,
+				11, 12, "      Image: \"123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/app:v2\","),
+				4, 5, "          Name: \"RACK_ENV\","), "\n"),
+		},
+		{
+			name:   "separate_hunks",
+			target: strings.Join(edit(edit(taskDefLines, 17, 18, "  Revision: 4,"), 2, 3, "      Cpu: 512,"), "\n"),
+		},
+		{
+			name:   "identical",
+			target: previous,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := UnifiedDiff(previous, c.target)
+			golden := filepath.Join("testdata", c.name+".golden")
+			if *update {
+				if err := ioutil.WriteFile(golden, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != string(want) {
+				t.Errorf("diff of %s is\n%s\nwant\n%s", c.name, got, want)
+			}
+		})
+	}
+}
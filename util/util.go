@@ -40,42 +40,20 @@ func ConfigAWS(c *cli.Context) error {
 	return nil
 }
 
+// PdiffTaskDef prints the unified diff from previous to target, which are String() of task definitions
 func PdiffTaskDef(target, previous string) {
-	targetStrSlice := strings.Split(target, "\n")
-	previousStrSlice := strings.Split(previous, "\n")
-	shorter := len(targetStrSlice) < len(previousStrSlice)
 	var buff bytes.Buffer
-	var minNum int
-	if shorter {
-		minNum = len(targetStrSlice)
-	} else {
-		minNum = len(previousStrSlice)
-	}
-	for i := 0; i < minNum; i++ {
-		tv := targetStrSlice[i]
-		pv := previousStrSlice[i]
-		if tv != pv {
-			_, _ = buff.WriteString("\x1b[32m")
-			_, _ = buff.WriteString("+" + tv)
-			_, _ = buff.WriteString("\x1b[0m\n")
-			_, _ = buff.WriteString("\x1b[31m")
-			_, _ = buff.WriteString("-" + pv)
-			_, _ = buff.WriteString("\x1b[0m\n")
-		} else {
-			_, _ = buff.WriteString(pv + "\n")
-		}
-	}
-	if shorter {
-		for i := minNum; i < len(previousStrSlice); i++ {
-			_, _ = buff.WriteString("\x1b[31m")
-			_, _ = buff.WriteString("-" + previousStrSlice[i])
-			_, _ = buff.WriteString("\x1b[0m\n")
-		}
-	} else {
-		for i := minNum; i < len(targetStrSlice); i++ {
-			_, _ = buff.WriteString("\x1b[32m")
-			_, _ = buff.WriteString("-" + targetStrSlice[i])
-			_, _ = buff.WriteString("\x1b[0m\n")
+	for _, line := range strings.SplitAfter(UnifiedDiff(previous, target), "\n") {
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "@@"):
+			_, _ = buff.WriteString("\x1b[36m" + strings.TrimSuffix(line, "\n") + "\x1b[0m\n")
+		case strings.HasPrefix(line, "+"):
+			_, _ = buff.WriteString("\x1b[32m" + strings.TrimSuffix(line, "\n") + "\x1b[0m\n")
+		case strings.HasPrefix(line, "-"):
+			_, _ = buff.WriteString("\x1b[31m" + strings.TrimSuffix(line, "\n") + "\x1b[0m\n")
+		default:
+			_, _ = buff.WriteString(line)
 		}
 	}
 	fmt.Println(buff.String())